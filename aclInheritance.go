@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// aceEdgeTypes are relationship types of ACE edges created by addACECyphers, extended rights
+// other than the known ones are imported with their own type and aren't resolved
+var aceEdgeTypes = []string{
+	"GenericAll", "WriteDacl", "WriteOwner", "GenericWrite", "Owns", "AllExtendedRights", "ForceChangePassword",
+	"AddMember", "AddAllowedToAct", "ReadLAPSPassword", "ReadGMSAPassword", "GetChanges", "GetChangesAll", "WriteProperty",
+}
+
+const (
+	// aclInheritanceMaxDepth is the maximum number of Contains edges between
+	// the source container and the object with inherited ACE
+	aclInheritanceMaxDepth = 15
+	// aclInheritanceBatchSize is number of objects resolved in a single transaction
+	aclInheritanceBatchSize = 500
+)
+
+// aclInheritancePendingStatement returns objectids of objects with inherited ACE edges without source
+func aclInheritancePendingStatement() string {
+	return fmt.Sprintf(`MATCH ()-[r:%s]->(child:Base)
+WHERE r.isacl = true AND r.isinherited = true AND r.inheritedfrom IS NULL
+RETURN DISTINCT child.objectid AS objectid`, strings.Join(aceEdgeTypes, "|"))
+}
+
+// aclInheritanceStatement sets 'inheritedfrom' of inherited ACE edges of objects in $list to objectid
+// of the nearest container the principal has the same explicit ACE edge on. containers between the
+// source and the object must not be ACL protected. since inherit-only ACEs of containers aren't
+// imported as edges the source is only approximated, it's resolved from security descriptors
+// for directory data
+func aclInheritanceStatement() string {
+	return fmt.Sprintf(`UNWIND $list AS id
+MATCH (p)-[r:%s]->(child:Base {objectid: id})
+WHERE r.isacl = true AND r.isinherited = true AND r.inheritedfrom IS NULL
+MATCH path = (source)-[:Contains*1..%d]->(child)
+WHERE NONE(n IN nodes(path)[1..-1] WHERE coalesce(n.aclprotected, false))
+AND size([(p)-[s]->(source) WHERE type(s) = type(r) AND s.isacl = true AND s.isinherited = false | s]) > 0
+WITH r, source, length(path) AS depth ORDER BY depth
+WITH r, collect(source)[0] AS source
+SET r.inheritedfrom = source.objectid
+RETURN count(r) AS resolved`, strings.Join(aceEdgeTypes, "|"), aclInheritanceMaxDepth)
+}
+
+// resolveACLInheritance sets source container of inherited ACE edges which don't have it yet,
+// objects are resolved in batches, each in its own transaction. it returns number of resolved edges
+func resolveACLInheritance(driver neo4j.Driver) (int64, error) {
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode: neo4j.AccessModeWrite,
+	})
+	defer session.Close()
+
+	pending, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		res, err := tx.Run(aclInheritancePendingStatement(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var ids []interface{}
+		for res.Next() {
+			id, _ := res.Record().Get("objectid")
+			ids = append(ids, id)
+		}
+		return ids, res.Err()
+	}, neo4j.WithTxTimeout(batchTimeout))
+	if err != nil {
+		return 0, err
+	}
+
+	ids := pending.([]interface{})
+	var total int64
+	for i := 0; i < len(ids); i += aclInheritanceBatchSize {
+		j := i + aclInheritanceBatchSize
+		if j > len(ids) {
+			j = len(ids)
+		}
+		record, err := neo4j.AsRecord(session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+			return neo4j.Single(tx.Run(aclInheritanceStatement(), map[string]interface{}{"list": ids[i:j]}))
+		}, neo4j.WithTxTimeout(batchTimeout)))
+		if err != nil {
+			return total, err
+		}
+		resolved, _ := record.Get("resolved")
+		n, _ := resolved.(int64)
+		total += n
+	}
+	return total, nil
+}
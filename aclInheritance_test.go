@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_aceEdgeTypes(t *testing.T) {
+	var aces []ace
+	for _, right := range []string{"GenericAll", "WriteDacl", "WriteOwner", "GenericWrite", "Owner", "ReadLAPSPassword", "ReadGMSAPassword"} {
+		aces = append(aces, ace{PrincipalSID: "S-1-5-21-1-512", PrincipalType: "Group", RightName: right, IsInherited: true})
+	}
+	for _, aceType := range []string{"All", "User-Force-Change-Password", "AddMember", "AllowedToAct", "GetChanges", "GetChangesAll"} {
+		aces = append(aces, ace{PrincipalSID: "S-1-5-21-1-512", PrincipalType: "Group", RightName: "ExtendedRight", AceType: aceType, IsInherited: true})
+	}
+	aces = append(aces, ace{PrincipalSID: "S-1-5-21-1-512", PrincipalType: "Group", RightName: "WriteProperty", AceType: "AddMember", IsInherited: true})
+	cyphers := make(map[string]*cypher)
+	addACECyphers(cyphers, aces, "S-1-5-21-1-1105", "User")
+
+	for _, c := range cyphers {
+		edgeType := relTypeRegex.FindStringSubmatch(c.statement)[1]
+		if !contains(aceEdgeTypes, edgeType) {
+			t.Errorf("ACE edge type %s isn't resolved by aclInheritanceStatement", edgeType)
+		}
+	}
+	if st := aclInheritanceStatement(); !strings.Contains(st, "[r:GenericAll|") || !strings.Contains(st, "[:Contains*1..15]") {
+		t.Errorf("aclInheritanceStatement() = %s", st)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// ADExplorer snapshot (.dat) format isn't documented, layout below follows
+// https://github.com/c3c/ADExplorerSnapshot.py
+//
+//	header     fixed size header, objects start right after it
+//	objects    numObjects entries of: objSize, tableSize, tableSize * (attrIndex, attrOffset)
+//	           followed by attribute values at object offset + attrOffset
+//	properties at header.propertiesOffset: numProperties entries describing attributes,
+//	           attrIndex of the object table refers to this list
+const (
+	adExplorerSignature  = "win-ad-ob"
+	adExplorerHeaderSize = 10 + 4 + 8 + 520 + 520 + 4*6
+	// sanity limit for counts read from the file
+	adExplorerMaxCount = 1 << 26
+)
+
+// ADSTYPE values used for attribute values
+// https://docs.microsoft.com/en-us/windows/win32/api/iads/ne-iads-adstypeenum
+const (
+	adsTypeDNString             = 1
+	adsTypeCaseExactString      = 2
+	adsTypeCaseIgnoreString     = 3
+	adsTypePrintableString      = 4
+	adsTypeNumericString        = 5
+	adsTypeBoolean              = 6
+	adsTypeInteger              = 7
+	adsTypeOctetString          = 8
+	adsTypeUTCTime              = 9
+	adsTypeLargeInteger         = 10
+	adsTypeObjectClass          = 12
+	adsTypeNTSecurityDescriptor = 25
+)
+
+type adExplorerHeader struct {
+	Signature        [10]byte
+	Marker           uint32
+	FileTime         uint64
+	Description      [520]byte
+	Server           [520]byte
+	NumObjects       uint32
+	NumAttributes    uint32
+	PropertiesOffset uint64
+	EndOffset        uint32
+	Unknown          uint32
+}
+
+type adExplorerProperty struct {
+	name         string
+	adsType      uint32
+	schemaIDGUID string
+}
+
+// adExplorerSnapshot reads objects from ADExplorer snapshot
+type adExplorerSnapshot struct {
+	r          io.ReaderAt
+	header     adExplorerHeader
+	properties []adExplorerProperty
+}
+
+func newADExplorerSnapshot(r io.ReaderAt) (*adExplorerSnapshot, error) {
+	s := &adExplorerSnapshot{r: r}
+	if err := binary.Read(io.NewSectionReader(r, 0, adExplorerHeaderSize), binary.LittleEndian, &s.header); err != nil {
+		return nil, fmt.Errorf("unable to read snapshot header %w", err)
+	}
+	if !bytes.HasPrefix(s.header.Signature[:], []byte(adExplorerSignature)) {
+		return nil, fmt.Errorf("not an ADExplorer snapshot")
+	}
+	if err := s.readProperties(); err != nil {
+		return nil, fmt.Errorf("unable to read snapshot properties %w", err)
+	}
+	return s, nil
+}
+
+// server returns name of the server snapshot was taken from
+func (s *adExplorerSnapshot) server() string {
+	return decodeUTF16(s.header.Server[:])
+}
+
+// created returns time when snapshot was taken
+func (s *adExplorerSnapshot) created() time.Time {
+	return time.Unix(fileTimeToUnix(int64(s.header.FileTime)), 0)
+}
+
+func (s *adExplorerSnapshot) readProperties() error {
+	r := &adExplorerReader{r: s.r, offset: int64(s.header.PropertiesOffset)}
+	count := r.uint32()
+	if r.err == nil && count > adExplorerMaxCount {
+		return fmt.Errorf("invalid number of properties %d", count)
+	}
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		var p adExplorerProperty
+		p.name = decodeUTF16(r.bytes(int(r.uint32())))
+		r.uint32() // unknown
+		p.adsType = r.uint32()
+		r.bytes(int(r.uint32())) // DN of attribute schema
+		if guid, err := guidToString(r.bytes(16)); err == nil {
+			p.schemaIDGUID = guid
+		}
+		r.bytes(16) // attributeSecurityGUID
+		r.bytes(4)  // unknown
+		s.properties = append(s.properties, p)
+	}
+	return r.err
+}
+
+// schemaGUIDs returns attribute names indexed by their schemaIDGUID
+func (s *adExplorerSnapshot) schemaGUIDs() map[string]string {
+	guids := make(map[string]string, len(s.properties))
+	for _, p := range s.properties {
+		if p.schemaIDGUID != "" {
+			guids[p.schemaIDGUID] = p.name
+		}
+	}
+	return guids
+}
+
+// entries reads all objects of the snapshot
+func (s *adExplorerSnapshot) entries() ([]*directoryEntry, error) {
+	if s.header.NumObjects > adExplorerMaxCount {
+		return nil, fmt.Errorf("invalid number of objects %d", s.header.NumObjects)
+	}
+	entries := make([]*directoryEntry, 0, s.header.NumObjects)
+	offset := int64(adExplorerHeaderSize)
+	for i := uint32(0); i < s.header.NumObjects; i++ {
+		e, size, err := s.readObject(offset)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read object %d at %d %w", i, offset, err)
+		}
+		if e.dn != "" {
+			entries = append(entries, e)
+		}
+		offset += size
+	}
+	return entries, nil
+}
+
+func (s *adExplorerSnapshot) readObject(offset int64) (*directoryEntry, int64, error) {
+	r := &adExplorerReader{r: s.r, offset: offset}
+	size := int64(r.uint32())
+	tableSize := r.uint32()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if size < 8 || tableSize > adExplorerMaxCount {
+		return nil, 0, fmt.Errorf("invalid object size %d table size %d", size, tableSize)
+	}
+	type mapping struct {
+		index  uint32
+		offset int32
+	}
+	table := make([]mapping, tableSize)
+	for i := range table {
+		table[i] = mapping{index: r.uint32(), offset: r.int32()}
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+
+	e := newDirectoryEntry("")
+	for _, m := range table {
+		if int(m.index) >= len(s.properties) {
+			return nil, 0, fmt.Errorf("invalid attribute index %d", m.index)
+		}
+		p := s.properties[m.index]
+		values, err := s.readValues(offset+int64(m.offset), p.adsType)
+		if err != nil {
+			return nil, 0, fmt.Errorf("attribute %s %w", p.name, err)
+		}
+		for _, v := range values {
+			e.add(p.name, v)
+		}
+	}
+	e.dn = e.get("distinguishedname")
+	return e, size, nil
+}
+
+// readValues reads attribute values at offset and converts them to LDAP string representation
+func (s *adExplorerSnapshot) readValues(offset int64, adsType uint32) ([][]byte, error) {
+	r := &adExplorerReader{r: s.r, offset: offset}
+	count := r.uint32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if count > adExplorerMaxCount {
+		return nil, fmt.Errorf("invalid number of values %d", count)
+	}
+
+	values := make([][]byte, 0, count)
+	switch adsType {
+	case adsTypeDNString, adsTypeCaseExactString, adsTypeCaseIgnoreString,
+		adsTypePrintableString, adsTypeNumericString, adsTypeObjectClass:
+		// offsets of null terminated UTF-16 strings relative to start of the attribute
+		offsets := make([]uint32, count)
+		for i := range offsets {
+			offsets[i] = r.uint32()
+		}
+		for _, o := range offsets {
+			sr := &adExplorerReader{r: s.r, offset: offset + int64(o)}
+			values = append(values, []byte(sr.utf16String()))
+			if sr.err != nil {
+				return nil, sr.err
+			}
+		}
+	case adsTypeOctetString:
+		lengths := make([]uint32, count)
+		for i := range lengths {
+			lengths[i] = r.uint32()
+		}
+		for _, l := range lengths {
+			values = append(values, r.bytes(int(l)))
+		}
+	case adsTypeNTSecurityDescriptor:
+		for i := uint32(0); i < count; i++ {
+			values = append(values, r.bytes(int(r.uint32())))
+		}
+	case adsTypeBoolean:
+		for i := uint32(0); i < count; i++ {
+			v := "FALSE"
+			if r.uint32() != 0 {
+				v = "TRUE"
+			}
+			values = append(values, []byte(v))
+		}
+	case adsTypeInteger:
+		for i := uint32(0); i < count; i++ {
+			values = append(values, []byte(strconv.FormatInt(int64(r.int32()), 10)))
+		}
+	case adsTypeLargeInteger:
+		for i := uint32(0); i < count; i++ {
+			values = append(values, []byte(strconv.FormatInt(r.int64(), 10)))
+		}
+	case adsTypeUTCTime:
+		// SYSTEMTIME
+		for i := uint32(0); i < count; i++ {
+			var st [8]uint16
+			for j := range st {
+				st[j] = r.uint16()
+			}
+			t := time.Date(int(st[0]), time.Month(st[1]), int(st[3]), int(st[4]), int(st[5]), int(st[6]), 0, time.UTC)
+			values = append(values, []byte(t.Format("20060102150405")+".0Z"))
+		}
+	default:
+		// unsupported types are skipped
+		return nil, nil
+	}
+	return values, r.err
+}
+
+// adExplorerReader reads little endian values at increasing offset,
+// first error is kept and following reads return zero values
+type adExplorerReader struct {
+	r      io.ReaderAt
+	offset int64
+	err    error
+}
+
+func (r *adExplorerReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > adExplorerMaxCount {
+		r.err = fmt.Errorf("invalid length %d", n)
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := r.r.ReadAt(b, r.offset); err != nil {
+		r.err = err
+		return nil
+	}
+	r.offset += int64(n)
+	return b
+}
+
+func (r *adExplorerReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *adExplorerReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *adExplorerReader) int32() int32 {
+	return int32(r.uint32())
+}
+
+func (r *adExplorerReader) int64() int64 {
+	if b := r.bytes(8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+// utf16String reads null terminated UTF-16 string
+func (r *adExplorerReader) utf16String() string {
+	var u []uint16
+	for r.err == nil {
+		c := r.uint16()
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// decodeUTF16 decodes little endian UTF-16 bytes, decoding stops at null character
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// parseADExplorerSnapshot reads ADExplorer snapshot and converts it to Bloodhound data
+func parseADExplorerSnapshot(r io.ReaderAt) (*bloodHoundRawData, error) {
+	s, err := newADExplorerSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	log.WithField("server", s.server()).Infof("reading ADExplorer snapshot of %d objects taken at %s", s.header.NumObjects, s.created().UTC().Format(time.RFC3339))
+	entries, err := s.entries()
+	if err != nil {
+		return nil, err
+	}
+	return convertDirectory(entries, s.schemaGUIDs()), nil
+}
+
+// parseSnapshot reads ADExplorer snapshot from r and returns its data and
+// sha256 hash. snapshot needs random access, inputs which aren't files are
+// read to memory
+func parseSnapshot(r io.Reader) (*bloodHoundRawData, string, error) {
+	h := sha256.New()
+	ra, ok := r.(io.ReaderAt)
+	if ok {
+		if _, err := io.Copy(h, r); err != nil {
+			return nil, "", err
+		}
+	} else {
+		b, err := ioutil.ReadAll(io.TeeReader(r, h))
+		if err != nil {
+			return nil, "", err
+		}
+		ra = bytes.NewReader(b)
+	}
+
+	data, err := parseADExplorerSnapshot(ra)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// snapshotAttribute is an attribute of test snapshot object, values are
+// strings for string and integer types and raw bytes for octet strings
+type snapshotAttribute struct {
+	name   string
+	values [][]byte
+}
+
+// testSnapshotProperties are properties of the test snapshot with their ADSTYPE
+var testSnapshotProperties = []adExplorerProperty{
+	{name: "distinguishedName", adsType: adsTypeDNString},
+	{name: "objectClass", adsType: adsTypeObjectClass},
+	{name: "objectSid", adsType: adsTypeOctetString},
+	{name: "objectGUID", adsType: adsTypeOctetString},
+	{name: "sAMAccountName", adsType: adsTypeCaseIgnoreString},
+	{name: "member", adsType: adsTypeDNString},
+	{name: "primaryGroupID", adsType: adsTypeInteger},
+	{name: "gPLink", adsType: adsTypeCaseIgnoreString},
+	{name: "displayName", adsType: adsTypeCaseIgnoreString},
+	{name: "dNSHostName", adsType: adsTypeCaseIgnoreString},
+	{name: "pwdLastSet", adsType: adsTypeLargeInteger},
+	{name: "adminCount", adsType: adsTypeInteger},
+}
+
+func utf16Bytes(s string, terminate bool) []byte {
+	var b bytes.Buffer
+	for _, c := range utf16.Encode([]rune(s)) {
+		binary.Write(&b, binary.LittleEndian, c)
+	}
+	if terminate {
+		b.Write([]byte{0, 0})
+	}
+	return b.Bytes()
+}
+
+func sidBytes(sid string) []byte {
+	parts := strings.Split(sid, "-")
+	authority, _ := strconv.ParseUint(parts[2], 10, 64)
+	b := bytes.NewBuffer([]byte{1, byte(len(parts) - 3)})
+	for i := 5; i >= 0; i-- {
+		b.WriteByte(byte(authority >> (8 * i)))
+	}
+	for _, p := range parts[3:] {
+		v, _ := strconv.ParseUint(p, 10, 32)
+		binary.Write(b, binary.LittleEndian, uint32(v))
+	}
+	return b.Bytes()
+}
+
+func guidBytes(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 16)
+}
+
+// writeSnapshotValues encodes attribute values the way ADExplorer stores them
+func writeSnapshotValues(adsType uint32, values [][]byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len(values)))
+	switch adsType {
+	case adsTypeOctetString:
+		for _, v := range values {
+			binary.Write(&b, binary.LittleEndian, uint32(len(v)))
+		}
+		for _, v := range values {
+			b.Write(v)
+		}
+	case adsTypeInteger:
+		for _, v := range values {
+			i, _ := strconv.Atoi(string(v))
+			binary.Write(&b, binary.LittleEndian, int32(i))
+		}
+	case adsTypeLargeInteger:
+		for _, v := range values {
+			i, _ := strconv.ParseInt(string(v), 10, 64)
+			binary.Write(&b, binary.LittleEndian, i)
+		}
+	default:
+		offset := 4 + 4*len(values)
+		var strs bytes.Buffer
+		for _, v := range values {
+			binary.Write(&b, binary.LittleEndian, uint32(offset+strs.Len()))
+			strs.Write(utf16Bytes(string(v), true))
+		}
+		b.Write(strs.Bytes())
+	}
+	return b.Bytes()
+}
+
+// writeSnapshot builds ADExplorer snapshot of the objects
+func writeSnapshot(t *testing.T, objects [][]snapshotAttribute) []byte {
+	t.Helper()
+	index := make(map[string]int)
+	for i, p := range testSnapshotProperties {
+		index[p.name] = i
+	}
+
+	var body bytes.Buffer
+	for _, attrs := range objects {
+		tableSize := 8 + 8*len(attrs)
+		var table, values bytes.Buffer
+		for _, a := range attrs {
+			i, ok := index[a.name]
+			if !ok {
+				t.Fatalf("unknown test property %s", a.name)
+			}
+			binary.Write(&table, binary.LittleEndian, uint32(i))
+			binary.Write(&table, binary.LittleEndian, int32(tableSize+values.Len()))
+			values.Write(writeSnapshotValues(testSnapshotProperties[i].adsType, a.values))
+		}
+		binary.Write(&body, binary.LittleEndian, uint32(tableSize+values.Len()))
+		binary.Write(&body, binary.LittleEndian, uint32(len(attrs)))
+		body.Write(table.Bytes())
+		body.Write(values.Bytes())
+	}
+
+	var header adExplorerHeader
+	copy(header.Signature[:], adExplorerSignature)
+	copy(header.Server[:], utf16Bytes("dc01.testlab.local", false))
+	header.FileTime = 132539328000000000
+	header.NumObjects = uint32(len(objects))
+	header.NumAttributes = uint32(len(testSnapshotProperties))
+	header.PropertiesOffset = uint64(adExplorerHeaderSize + body.Len())
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, header)
+	b.Write(body.Bytes())
+	binary.Write(&b, binary.LittleEndian, uint32(len(testSnapshotProperties)))
+	for i, p := range testSnapshotProperties {
+		name := utf16Bytes(p.name, true)
+		binary.Write(&b, binary.LittleEndian, uint32(len(name)))
+		b.Write(name)
+		binary.Write(&b, binary.LittleEndian, uint32(0))
+		binary.Write(&b, binary.LittleEndian, p.adsType)
+		dn := utf16Bytes("CN="+p.name+",CN=Schema,CN=Configuration,DC=testlab,DC=local", true)
+		binary.Write(&b, binary.LittleEndian, uint32(len(dn)))
+		b.Write(dn)
+		b.Write(guidBytes(byte(i)))
+		b.Write(guidBytes(0))
+		b.Write(make([]byte, 4))
+	}
+	return b.Bytes()
+}
+
+func attr(name string, values ...string) snapshotAttribute {
+	a := snapshotAttribute{name: name}
+	for _, v := range values {
+		a.values = append(a.values, []byte(v))
+	}
+	return a
+}
+
+func rawAttr(name string, value []byte) snapshotAttribute {
+	return snapshotAttribute{name: name, values: [][]byte{value}}
+}
+
+func Test_parseADExplorerSnapshot(t *testing.T) {
+	domainSID := "S-1-5-21-1-2-3"
+	snapshot := writeSnapshot(t, [][]snapshotAttribute{
+		{
+			attr("distinguishedName", "DC=testlab,DC=local"),
+			attr("objectClass", "top", "domain", "domainDNS"),
+			rawAttr("objectSid", sidBytes(domainSID)),
+			attr("gPLink", "[LDAP://cn={GPO},cn=policies,cn=system,DC=testlab,DC=local;2]"),
+		},
+		{
+			attr("distinguishedName", "OU=Servers,DC=testlab,DC=local"),
+			attr("objectClass", "top", "organizationalUnit"),
+			rawAttr("objectGUID", guidBytes(0xAA)),
+		},
+		{
+			attr("distinguishedName", "CN={GPO},CN=Policies,CN=System,DC=testlab,DC=local"),
+			attr("objectClass", "top", "container", "groupPolicyContainer"),
+			rawAttr("objectGUID", guidBytes(0xBB)),
+			attr("displayName", "Default Domain Policy"),
+		},
+		{
+			attr("distinguishedName", "CN=Domain Admins,CN=Users,DC=testlab,DC=local"),
+			attr("objectClass", "top", "group"),
+			rawAttr("objectSid", sidBytes(domainSID+"-512")),
+			attr("sAMAccountName", "Domain Admins"),
+			attr("member", "CN=Administrator,CN=Users,DC=testlab,DC=local", "CN=S-1-5-21-9-9-9-1000,CN=ForeignSecurityPrincipals,DC=testlab,DC=local"),
+			attr("adminCount", "1"),
+		},
+		{
+			attr("distinguishedName", "CN=Administrator,CN=Users,DC=testlab,DC=local"),
+			attr("objectClass", "top", "person", "organizationalPerson", "user"),
+			rawAttr("objectSid", sidBytes(domainSID+"-500")),
+			attr("sAMAccountName", "Administrator"),
+			attr("primaryGroupID", "513"),
+			attr("pwdLastSet", "132539328000000000"),
+		},
+		{
+			attr("distinguishedName", "CN=SRV01,OU=Servers,DC=testlab,DC=local"),
+			attr("objectClass", "top", "person", "organizationalPerson", "user", "computer"),
+			rawAttr("objectSid", sidBytes(domainSID+"-1001")),
+			attr("sAMAccountName", "SRV01$"),
+			attr("dNSHostName", "srv01.testlab.local"),
+			attr("primaryGroupID", "515"),
+		},
+	})
+
+	data, err := parseADExplorerSnapshot(bytes.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("parseADExplorerSnapshot() error = %v", err)
+	}
+
+	if diff := cmp.Diff(meta{Type: metaTypeAll, Count: 6}, data.Meta); diff != "" {
+		t.Errorf("meta mismatch (-want got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"domains", "ous", "gpos", "groups", "users", "computers"}, data.contentTypes()); diff != "" {
+		t.Errorf("contentTypes() mismatch (-want got):\n%s", diff)
+	}
+
+	ouID := "AAAAAAAA-AAAA-AAAA-AAAA-AAAAAAAAAAAA"
+	gpoID := "BBBBBBBB-BBBB-BBBB-BBBB-BBBBBBBBBBBB"
+
+	d := data.Domains[0]
+	if diff := cmp.Diff([]link{{GUID: gpoID, IsEnforced: true}}, d.Links); diff != "" {
+		t.Errorf("domain links mismatch (-want got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{ouID}, d.ChildOus); diff != "" {
+		t.Errorf("domain child OUs mismatch (-want got):\n%s", diff)
+	}
+	if d.Properties["name"] != "TESTLAB.LOCAL" {
+		t.Errorf("domain name = %v", d.Properties["name"])
+	}
+
+	if diff := cmp.Diff([]string{"S-1-5-21-1-2-3-1001"}, data.OUs[0].Computers); diff != "" {
+		t.Errorf("OU computers mismatch (-want got):\n%s", diff)
+	}
+	if data.Gpos[0].Properties["name"] != "DEFAULT DOMAIN POLICY@TESTLAB.LOCAL" {
+		t.Errorf("GPO name = %v", data.Gpos[0].Properties["name"])
+	}
+
+	g := data.Groups[0]
+	wantMembers := []member{
+		{MemberID: "S-1-5-21-1-2-3-500", MemberType: "User"},
+		{MemberID: "S-1-5-21-9-9-9-1000", MemberType: "Base"},
+	}
+	if diff := cmp.Diff(wantMembers, g.Members); diff != "" {
+		t.Errorf("group members mismatch (-want got):\n%s", diff)
+	}
+	if g.Properties["highvalue"] != true || g.Properties["admincount"] != true {
+		t.Errorf("group properties = %v", g.Properties)
+	}
+
+	u := data.Users[0]
+	if u.ObjectIdentifier != "S-1-5-21-1-2-3-500" || u.PrimaryGroupSid != "S-1-5-21-1-2-3-513" {
+		t.Errorf("user = %s primary group %s", u.ObjectIdentifier, u.PrimaryGroupSid)
+	}
+	if u.Properties["name"] != "ADMINISTRATOR@TESTLAB.LOCAL" || u.Properties["pwdlastset"] != int64(1609459200) {
+		t.Errorf("user properties = %v", u.Properties)
+	}
+
+	c := data.Computers[0]
+	if c.Properties["name"] != "SRV01.TESTLAB.LOCAL" || c.PrimaryGroupSid != "S-1-5-21-1-2-3-515" {
+		t.Errorf("computer = %v primary group %s", c.Properties, c.PrimaryGroupSid)
+	}
+}
+
+func Test_parseADExplorerSnapshot_invalid(t *testing.T) {
+	if _, err := parseADExplorerSnapshot(bytes.NewReader([]byte(`{"meta": {}}`))); err == nil {
+		t.Error("expected error for json input")
+	}
+
+	snapshot := writeSnapshot(t, [][]snapshotAttribute{{attr("distinguishedName", "DC=testlab,DC=local")}})
+	if _, err := parseADExplorerSnapshot(bytes.NewReader(snapshot[:len(snapshot)-20])); err == nil {
+		t.Error("expected error for truncated snapshot")
+	}
+}
@@ -0,0 +1,779 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// report formats written by 'analyze' command
+const (
+	reportJSON = "json"
+	reportCSV  = "csv"
+	reportHTML = "html"
+)
+
+// edges which can be abused to move from source to target, used by path queries
+const attackEdges = "MemberOf|HasSession|AdminTo|AllExtendedRights|AddMember|ForceChangePassword|GenericAll|GenericWrite|Owns|WriteDacl|WriteOwner|" +
+	"CanRDP|ExecuteDCOM|CanPSRemote|AllowedToDelegate|AllowedToAct|AddAllowedToAct|ReadLAPSPassword|ReadGMSAPassword|Contains|GpLink|SQLAdmin|HasSIDHistory"
+
+// analysisQuery is a cypher query run by 'analyze' command, rows it returns are written to the report
+type analysisQuery struct {
+	Name        string `json:"name" yaml:"name"`
+	Title       string `json:"title" yaml:"title"`
+	Description string `json:"description" yaml:"description"`
+	Query       string `json:"query" yaml:"query"`
+}
+
+var builtinQueries = []analysisQuery{
+	{
+		Name:        "kerberoastable-users-path-to-da",
+		Title:       "Kerberoastable users with path to Domain Admins",
+		Description: "Enabled users with SPN and the shortest path from them to Domain Admins group of any domain",
+		Query: `MATCH (u:User {hasspn: true, enabled: true})
+MATCH (g:Group) WHERE g.objectid ENDS WITH '-512'
+MATCH p = shortestPath((u)-[:` + attackEdges + `*1..]->(g))
+RETURN u.name AS user, g.name AS group, length(p) AS hops, p AS path
+ORDER BY hops, user`,
+	},
+	{
+		Name:        "kerberoastable-users",
+		Title:       "Kerberoastable users",
+		Description: "Enabled users with SPN, service tickets of these users can be cracked offline",
+		Query: `MATCH (u:User {hasspn: true, enabled: true})
+RETURN u.name AS user, u.serviceprincipalnames AS spns, u.admincount AS admincount, u.pwdlastset AS pwdlastset
+ORDER BY user`,
+	},
+	{
+		Name:        "asrep-roastable-users",
+		Title:       "AS-REP roastable users",
+		Description: "Enabled users which don't require Kerberos pre-authentication",
+		Query: `MATCH (u:User {dontreqpreauth: true, enabled: true})
+RETURN u.name AS user, u.admincount AS admincount
+ORDER BY user`,
+	},
+	{
+		Name:        "unconstrained-delegation",
+		Title:       "Unconstrained delegation hosts",
+		Description: "Computers and users trusted for unconstrained delegation, domain controllers are listed separately as they always are",
+		Query: `MATCH (n {unconstraineddelegation: true}) WHERE n:Computer OR n:User
+OPTIONAL MATCH (n)-[:MemberOf*1..]->(dc:Group) WHERE dc.objectid ENDS WITH '-516'
+WITH n, count(dc) > 0 AS domaincontroller
+RETURN n.name AS name, [l IN labels(n) WHERE l <> 'Base'] AS labels, domaincontroller
+ORDER BY domaincontroller, name`,
+	},
+	{
+		Name:        "constrained-delegation",
+		Title:       "Constrained delegation",
+		Description: "Principals allowed to delegate to services of other computers, with protocol transition they can impersonate any user without their ticket",
+		Query: `MATCH (n)-[r:AllowedToDelegate]->(t)
+RETURN n.name AS principal, t.name AS target, r.protocoltransition AS protocoltransition, r.spns AS spns
+ORDER BY principal, target`,
+	},
+	{
+		Name:        "dcsync-principals",
+		Title:       "DCSync principals",
+		Description: "Principals which can replicate secrets of a domain directly or through group membership",
+		Query: `MATCH (n)-[:MemberOf*0..]->()-[r:GetChanges|GetChangesAll|AllExtendedRights|GenericAll]->(d:Domain)
+WITH n, d, collect(DISTINCT type(r)) AS rights
+WHERE 'AllExtendedRights' IN rights OR 'GenericAll' IN rights OR ('GetChanges' IN rights AND 'GetChangesAll' IN rights)
+RETURN n.name AS principal, [l IN labels(n) WHERE l <> 'Base'] AS labels, d.name AS domain, rights
+ORDER BY domain, principal`,
+	},
+	{
+		Name:        "domain-users-path-to-tier0",
+		Title:       "Shortest paths from Domain Users to Tier 0",
+		Description: "Shortest paths from Domain Users group to high value objects, any domain user can follow them",
+		Query: `MATCH (g:Group) WHERE g.objectid ENDS WITH '-513'
+MATCH (t {highvalue: true}) WHERE t <> g
+MATCH p = shortestPath((g)-[:` + attackEdges + `*1..]->(t))
+RETURN g.name AS source, t.name AS target, length(p) AS hops, p AS path
+ORDER BY hops, target`,
+	},
+	{
+		Name:        "domain-admins",
+		Title:       "Domain Admins members",
+		Description: "Users and computers which are direct or nested members of Domain Admins",
+		Query: `MATCH (n)-[:MemberOf*1..]->(g:Group) WHERE g.objectid ENDS WITH '-512' AND (n:User OR n:Computer)
+RETURN DISTINCT n.name AS member, g.name AS group, n.enabled AS enabled
+ORDER BY group, member`,
+	},
+	{
+		Name:        "computers-without-laps",
+		Title:       "Enabled computers without LAPS",
+		Description: "Local administrator password of these computers may be shared",
+		Query: `MATCH (c:Computer {enabled: true, haslaps: false})
+RETURN c.name AS computer, c.operatingsystem AS operatingsystem
+ORDER BY computer`,
+	},
+	{
+		Name:        "sid-history-abusable-trusts",
+		Title:       "Trusts abusable with SID history",
+		Description: "Trusts which don't filter SIDs in SID history, compromise of the trusted domain can be escalated to the trusting domain",
+		Query: `MATCH (a:Domain)-[r:TrustedBy]->(b:Domain) WHERE r.sidhistoryabusable = true
+RETURN a.name AS trusted, b.name AS trusting, r.trusttype AS trusttype, r.trustdirection AS direction
+ORDER BY trusted, trusting`,
+	},
+	{
+		Name:        "domains",
+		Title:       "Domains",
+		Description: "Imported domains and their functional level",
+		Query: `MATCH (d:Domain)
+RETURN d.name AS domain, d.objectid AS objectid, d.functionallevel AS functionallevel
+ORDER BY domain`,
+	},
+	{
+		Name:        "domain-trusts",
+		Title:       "Domain trusts",
+		Description: "Active and disabled trusts between domains",
+		Query: `MATCH (a:Domain)-[r:TrustedBy|DisabledTrust]->(b:Domain)
+RETURN a.name AS source, b.name AS target, type(r) AS edge, r.trusttype AS trusttype, r.trustdirection AS direction,
+r.transitive AS transitive, r.sidfiltering AS sidfiltering
+ORDER BY source, target`,
+	},
+	{
+		Name:        "domain-controllers",
+		Title:       "Domain controllers",
+		Description: "Computers which are members of Domain Controllers group",
+		Query: `MATCH (c:Computer)-[:MemberOf*1..]->(g:Group) WHERE g.objectid ENDS WITH '-516'
+RETURN DISTINCT c.name AS computer, c.operatingsystem AS operatingsystem, c.enabled AS enabled
+ORDER BY computer`,
+	},
+	{
+		Name:        "enterprise-admins",
+		Title:       "Enterprise Admins members",
+		Description: "Users and computers which are direct or nested members of Enterprise Admins",
+		Query: `MATCH (n)-[:MemberOf*1..]->(g:Group) WHERE g.objectid ENDS WITH '-519' AND (n:User OR n:Computer)
+RETURN DISTINCT n.name AS member, g.name AS group, n.enabled AS enabled
+ORDER BY group, member`,
+	},
+	{
+		Name:        "highvalue-objects",
+		Title:       "High value objects",
+		Description: "Objects marked as high value, by collectors or tiering config",
+		Query: `MATCH (n {highvalue: true})
+RETURN n.name AS name, [l IN labels(n) WHERE l <> 'Base'] AS labels, n.tier AS tier
+ORDER BY name`,
+	},
+	{
+		Name:        "owned-principals-path-to-tier0",
+		Title:       "Shortest paths from owned principals to Tier 0",
+		Description: "Shortest paths from principals marked as owned to high value objects",
+		Query: `MATCH (o {owned: true})
+MATCH (t {highvalue: true}) WHERE t <> o
+MATCH p = shortestPath((o)-[:` + attackEdges + `*1..]->(t))
+RETURN o.name AS owned, t.name AS target, length(p) AS hops, p AS path
+ORDER BY hops, owned`,
+	},
+	{
+		Name:        "domain-admin-sessions-outside-dcs",
+		Title:       "Domain Admins sessions on computers other than domain controllers",
+		Description: "Credentials of these admins can be stolen from computers with lower protection than domain controllers",
+		Query: `MATCH (u:User)-[:MemberOf*1..]->(g:Group) WHERE g.objectid ENDS WITH '-512'
+MATCH (c:Computer)-[:HasSession]->(u)
+WHERE NONE(dc IN [(c)-[:MemberOf*1..]->(dc:Group) | dc] WHERE dc.objectid ENDS WITH '-516')
+RETURN DISTINCT u.name AS user, c.name AS computer
+ORDER BY user, computer`,
+	},
+	{
+		Name:        "tier0-sessions",
+		Title:       "Sessions of high value users",
+		Description: "Computers where members of high value groups have sessions",
+		Query: `MATCH (u:User)-[:MemberOf*1..]->(g:Group {highvalue: true})
+MATCH (c:Computer)-[:HasSession]->(u)
+RETURN DISTINCT u.name AS user, c.name AS computer, c.highvalue AS highvaluecomputer
+ORDER BY user, computer`,
+	},
+	{
+		Name:        "kerberoastable-tier0-members",
+		Title:       "Kerberoastable members of high value groups",
+		Description: "Enabled users with SPN which are direct or nested members of high value groups",
+		Query: `MATCH (u:User {hasspn: true, enabled: true})-[:MemberOf*1..]->(g:Group {highvalue: true})
+RETURN u.name AS user, collect(DISTINCT g.name) AS groups
+ORDER BY user`,
+	},
+	{
+		Name:        "broad-groups-local-rights",
+		Title:       "Local rights of broad groups",
+		Description: "Computers where Everyone, Authenticated Users, Domain Users or Domain Computers are local admins or can log on remotely",
+		Query: `MATCH (g:Group)-[r:AdminTo|CanRDP|ExecuteDCOM|CanPSRemote]->(c:Computer)
+WHERE g.objectid ENDS WITH '-513' OR g.objectid ENDS WITH '-515' OR g.objectid ENDS WITH 'S-1-5-11' OR g.objectid ENDS WITH 'S-1-1-0'
+RETURN g.name AS group, type(r) AS right, count(c) AS computers, collect(c.name)[..20] AS examples
+ORDER BY computers DESC, group`,
+	},
+	{
+		Name:        "broad-groups-acl-rights",
+		Title:       "Control rights of broad groups",
+		Description: "Objects Everyone, Authenticated Users, Domain Users or Domain Computers have control rights on",
+		Query: `MATCH (g:Group)-[r:GenericAll|GenericWrite|WriteDacl|WriteOwner|Owns|AllExtendedRights|ForceChangePassword|AddMember|AddAllowedToAct]->(n)
+WHERE g.objectid ENDS WITH '-513' OR g.objectid ENDS WITH '-515' OR g.objectid ENDS WITH 'S-1-5-11' OR g.objectid ENDS WITH 'S-1-1-0'
+RETURN g.name AS group, type(r) AS right, n.name AS target, [l IN labels(n) WHERE l <> 'Base'] AS labels
+ORDER BY group, right, target`,
+	},
+	{
+		Name:        "foreign-group-members",
+		Title:       "Members of groups in other domains",
+		Description: "Users and groups which are members of groups of another domain",
+		Query: `MATCH (n)-[:MemberOf]->(g:Group) WHERE (n:User OR n:Group) AND n.domain <> g.domain
+RETURN n.name AS member, n.domain AS memberdomain, g.name AS group, g.domain AS groupdomain
+ORDER BY groupdomain, group, member`,
+	},
+	{
+		Name:        "gpo-controllers",
+		Title:       "Principals which can modify GPOs",
+		Description: "Principals with control rights on GPOs, they can run code on every computer the GPO applies to",
+		Query: `MATCH (n)-[r:GenericAll|GenericWrite|WriteDacl|WriteOwner|Owns]->(g:GPO)
+RETURN g.name AS gpo, n.name AS principal, [l IN labels(n) WHERE l <> 'Base'] AS labels, collect(type(r)) AS rights
+ORDER BY gpo, principal`,
+	},
+	{
+		Name:        "laps-readers",
+		Title:       "LAPS password readers",
+		Description: "Principals which can read local administrator passwords managed by LAPS",
+		Query: `MATCH (n)-[:ReadLAPSPassword]->(c:Computer)
+RETURN n.name AS principal, count(c) AS computers, collect(c.name)[..20] AS examples
+ORDER BY computers DESC, principal`,
+	},
+	{
+		Name:        "gmsa-readers",
+		Title:       "gMSA password readers",
+		Description: "Principals which can read passwords of group managed service accounts",
+		Query: `MATCH (n)-[:ReadGMSAPassword]->(u:User)
+RETURN n.name AS principal, u.name AS account
+ORDER BY account, principal`,
+	},
+	{
+		Name:        "resource-based-constrained-delegation",
+		Title:       "Resource-based constrained delegation",
+		Description: "Principals allowed to act on behalf of other users on computers, and principals which can grant it",
+		Query: `MATCH (n)-[r:AllowedToAct|AddAllowedToAct]->(c:Computer)
+RETURN c.name AS computer, n.name AS principal, type(r) AS edge
+ORDER BY computer, edge, principal`,
+	},
+	{
+		Name:        "sql-admins",
+		Title:       "SQL Server admins",
+		Description: "Accounts SQL Server instances run as, they are admins of the instance",
+		Query: `MATCH (u:User)-[r:SQLAdmin]->(c:Computer)
+RETURN u.name AS user, c.name AS computer, r.port AS port
+ORDER BY computer, user`,
+	},
+	{
+		Name:        "unsupported-operating-systems",
+		Title:       "Enabled computers with unsupported operating systems",
+		Description: "Computers running operating systems which no longer get security updates",
+		Query: `MATCH (c:Computer {enabled: true}) WHERE c.operatingsystem =~ '(?i).*(2000|2003|2008|xp|vista|windows 7|windows 8|millennium).*'
+RETURN c.name AS computer, c.operatingsystem AS operatingsystem
+ORDER BY operatingsystem, computer`,
+	},
+	{
+		Name:        "password-never-expires",
+		Title:       "Enabled users with password which never expires",
+		Description: "Passwords of these users aren't rotated by password policy",
+		Query: `MATCH (u:User {pwdneverexpires: true, enabled: true})
+RETURN u.name AS user, u.admincount AS admincount, u.pwdlastset AS pwdlastset
+ORDER BY user`,
+	},
+	{
+		Name:        "password-not-required",
+		Title:       "Enabled users which may have empty password",
+		Description: "Users with PASSWD_NOTREQD flag, password policy doesn't apply to them",
+		Query: `MATCH (u:User {passwordnotreqd: true, enabled: true})
+RETURN u.name AS user, u.admincount AS admincount
+ORDER BY user`,
+	},
+	{
+		Name:        "password-in-description",
+		Title:       "Users with password in description",
+		Description: "Descriptions are readable by all domain users",
+		Query: `MATCH (u:User) WHERE toLower(u.description) CONTAINS 'pass' OR toLower(u.description) CONTAINS 'pwd'
+RETURN u.name AS user, u.enabled AS enabled, u.description AS description
+ORDER BY user`,
+	},
+	{
+		Name:        "inherited-ace-sources",
+		Title:       "Sources of inherited ACEs",
+		Description: "Containers inherited ACEs originate from, ACL can be fixed on the container rather than on every descendant",
+		Query: `MATCH (n)-[r {isacl: true, isinherited: true}]->() WHERE r.inheritedfrom IS NOT NULL
+WITH r.inheritedfrom AS source, type(r) AS right, n.name AS principal, count(*) AS objects
+OPTIONAL MATCH (c:Base {objectid: source})
+RETURN coalesce(c.name, source) AS container, right, principal, objects
+ORDER BY objects DESC, container, principal`,
+	},
+}
+
+var queryNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func analyzeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "analyze",
+		Usage: "run attack path queries against imported data and write report",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "bhi-queries",
+				Usage: "yaml or json file with list of additional queries (name, title, description, query), queries with name of built-in query replace it",
+			},
+			&cli.BoolFlag{
+				Name:  "bhi-no-builtin-queries",
+				Usage: "only run queries from '--bhi-queries' files",
+			},
+			&cli.StringSliceFlag{
+				Name:  "bhi-query",
+				Usage: "name of query to run, can be specified multiple times. all queries are run if not set",
+			},
+			&cli.StringFlag{
+				Name:  "bhi-output-dir",
+				Usage: "folder where report files are written",
+				Value: "report",
+			},
+			&cli.StringSliceFlag{
+				Name:  "bhi-report-format",
+				Usage: "format of the report, 'json', 'csv' (file per query) or 'html'",
+				Value: cli.NewStringSlice(reportJSON, reportCSV, reportHTML),
+			},
+			&cli.DurationFlag{
+				Name:  "bhi-query-timeout",
+				Usage: "timeout of each query",
+				Value: 5 * time.Minute,
+			},
+		},
+		Action: analyze,
+	}
+}
+
+func analyze(c *cli.Context) error {
+	for _, f := range c.StringSlice("bhi-report-format") {
+		switch f {
+		case reportJSON, reportCSV, reportHTML:
+		default:
+			return fmt.Errorf("unsupported report format %q", f)
+		}
+	}
+
+	queries, err := loadAnalysisQueries(c.StringSlice("bhi-queries"), !c.Bool("bhi-no-builtin-queries"))
+	if err != nil {
+		return err
+	}
+	queries, err = selectQueries(queries, c.StringSlice("bhi-query"))
+	if err != nil {
+		return err
+	}
+	if len(queries) == 0 {
+		return fmt.Errorf("no queries to run")
+	}
+
+	driver, err := connect(c)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	go gracefulShutdown(cancel)
+
+	report := &analysisReport{
+		Generated: time.Now().UTC(),
+		Database:  c.String("bhi-neo4j-url"),
+	}
+	var failed int
+	var connErr error
+	report.Results, failed, connErr = runAnalysisQueries(ctx, queries, func(q analysisQuery) (*analysisResult, error) {
+		return runAnalysisQuery(ctx, driver, q, c.Duration("bhi-query-timeout"))
+	})
+
+	if err := report.write(c.String("bhi-output-dir"), c.StringSlice("bhi-report-format")); err != nil {
+		return fmt.Errorf("unable to write report %w", err)
+	}
+	log.Infof("report written to %s", c.String("bhi-output-dir"))
+
+	if connErr != nil {
+		return connErr
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("analysis interrupted, %d of %d queries were run", len(report.Results), len(queries))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queries failed", failed, len(queries))
+	}
+	return nil
+}
+
+// runAnalysisQueries runs queries in order and returns their results and number of failed queries,
+// failed queries are returned with their error. it stops after the query which lost connection
+// to neo4j, returned as connectivity error, and when ctx is cancelled queries which were
+// completed are returned
+func runAnalysisQueries(ctx context.Context, queries []analysisQuery, run func(analysisQuery) (*analysisResult, error)) ([]*analysisResult, int, error) {
+	var results []*analysisResult
+	failed := 0
+	for _, q := range queries {
+		res, err := run(q)
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, res)
+		if err != nil {
+			failed++
+			if isNeo4jConnectivityError(err) {
+				log.WithField("query", q.Name).WithError(err).Error("lost connection to neo4j")
+				return results, failed, connectivityError(err)
+			}
+			log.WithField("query", q.Name).WithError(err).Error("query failed")
+			continue
+		}
+		log.WithField("query", q.Name).WithField("rows", len(res.Rows)).Info("query completed")
+	}
+	return results, failed, nil
+}
+
+// loadAnalysisQueries returns built-in queries followed by queries from files, query with name
+// of an existing query replaces it. files are parsed as yaml, which also accepts json
+func loadAnalysisQueries(files []string, builtin bool) ([]analysisQuery, error) {
+	var queries []analysisQuery
+	if builtin {
+		queries = append(queries, builtinQueries...)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read queries %w", err)
+		}
+		var fileQueries []analysisQuery
+		if err := yaml.Unmarshal(b, &fileQueries); err != nil {
+			return nil, fmt.Errorf("invalid queries file %s: %w", f, err)
+		}
+	next:
+		for _, q := range fileQueries {
+			if !queryNameRegex.MatchString(q.Name) {
+				return nil, fmt.Errorf("invalid query name %q in %s, only letters, digits, '-' and '_' are allowed", q.Name, f)
+			}
+			if strings.TrimSpace(q.Query) == "" {
+				return nil, fmt.Errorf("query %q in %s is empty", q.Name, f)
+			}
+			for i := range queries {
+				if queries[i].Name == q.Name {
+					queries[i] = q
+					continue next
+				}
+			}
+			queries = append(queries, q)
+		}
+	}
+	return queries, nil
+}
+
+// selectQueries returns queries with given names, all queries are returned if names are empty
+func selectQueries(queries []analysisQuery, names []string) ([]analysisQuery, error) {
+	if len(names) == 0 {
+		return queries, nil
+	}
+	var selected []analysisQuery
+	for _, name := range names {
+		found := false
+		for _, q := range queries {
+			if q.Name == name {
+				selected = append(selected, q)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown query %q, available queries: %s", name, strings.Join(queryNames(queries), ", "))
+		}
+	}
+	return selected, nil
+}
+
+// analysisResult is the result of a query, values are converted by reportValue
+type analysisResult struct {
+	analysisQuery
+	Columns    []string        `json:"columns"`
+	Rows       [][]interface{} `json:"rows"`
+	DurationMS int64           `json:"duration_ms"`
+	Error      string          `json:"error,omitempty"`
+}
+
+type analysisReport struct {
+	Generated time.Time         `json:"generated"`
+	Database  string            `json:"database"`
+	Results   []*analysisResult `json:"results"`
+}
+
+// runAnalysisQuery runs query in read transaction, failed query is returned with its error.
+// running query can't be cancelled, when ctx is cancelled ctx error is returned without waiting
+// for the query. its session is closed once it returns, which happens when the driver is closed
+func runAnalysisQuery(ctx context.Context, driver neo4j.Driver, q analysisQuery, timeout time.Duration) (*analysisResult, error) {
+	done := make(chan error, 1)
+	res := &analysisResult{analysisQuery: q}
+	go func() {
+		session := driver.NewSession(neo4j.SessionConfig{
+			AccessMode: neo4j.AccessModeRead,
+		})
+		defer session.Close()
+		done <- readAnalysisResult(session, res, timeout)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			res.Error = err.Error()
+		}
+		return res, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readAnalysisResult runs the query and reads its columns and rows into res
+func readAnalysisResult(session neo4j.Session, res *analysisResult, timeout time.Duration) error {
+	start := time.Now()
+	_, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(res.Query, nil)
+		if err != nil {
+			return nil, err
+		}
+		if res.Columns, err = result.Keys(); err != nil {
+			return nil, err
+		}
+		res.Rows = nil
+		for result.Next() {
+			values := result.Record().Values
+			row := make([]interface{}, len(values))
+			for i, v := range values {
+				row[i] = reportValue(v)
+			}
+			res.Rows = append(res.Rows, row)
+		}
+		return nil, result.Err()
+	}, neo4j.WithTxTimeout(timeout))
+	res.DurationMS = time.Since(start).Milliseconds()
+	return err
+}
+
+// reportValue converts value returned by neo4j to json friendly value, nodes are
+// replaced by their name and paths are rendered as '(A)-[MemberOf]->(B)'
+func reportValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case neo4j.Node:
+		return nodeName(v)
+	case neo4j.Relationship:
+		return v.Type
+	case neo4j.Path:
+		return pathString(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = reportValue(v[i])
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for k := range v {
+			values[k] = reportValue(v[k])
+		}
+		return values
+	case neo4j.Date, neo4j.LocalDateTime, neo4j.LocalTime, neo4j.Time, neo4j.Duration:
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+func nodeName(n neo4j.Node) string {
+	for _, p := range []string{"name", "objectid"} {
+		if s, ok := n.Props[p].(string); ok && s != "" {
+			return s
+		}
+	}
+	return fmt.Sprintf("%d", n.Id)
+}
+
+func pathString(p neo4j.Path) string {
+	if len(p.Nodes) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("(" + nodeName(p.Nodes[0]) + ")")
+	for i, r := range p.Relationships {
+		if i+1 >= len(p.Nodes) {
+			break
+		}
+		if r.StartId == p.Nodes[i].Id {
+			sb.WriteString("-[" + r.Type + "]->")
+		} else {
+			sb.WriteString("<-[" + r.Type + "]-")
+		}
+		sb.WriteString("(" + nodeName(p.Nodes[i+1]) + ")")
+	}
+	return sb.String()
+}
+
+// formatValue returns text of a report value used in csv and html reports
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = formatValue(v[i])
+		}
+		return strings.Join(values, ", ")
+	case map[string]interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// write writes report files of given formats to dir
+func (r *analysisReport) write(dir string, formats []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range formats {
+		var err error
+		switch f {
+		case reportJSON:
+			err = writeReportFile(filepath.Join(dir, "report.json"), r.writeJSON)
+		case reportHTML:
+			err = writeReportFile(filepath.Join(dir, "report.html"), r.writeHTML)
+		case reportCSV:
+			for _, res := range r.Results {
+				if res.Error != "" {
+					continue
+				}
+				if err = writeReportFile(filepath.Join(dir, res.Name+".csv"), res.writeCSV); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReportFile(name string, write func(w io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *analysisReport) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// writeCSV writes header with column names followed by rows of the result
+func (res *analysisResult) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(res.Columns); err != nil {
+		return err
+	}
+	for _, row := range res.Rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = formatValue(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reportTemplate renders self-contained html report, it doesn't load any external resources
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"format": formatValue,
+	"title": func(res *analysisResult) string {
+		if res.Title != "" {
+			return res.Title
+		}
+		return res.Name
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bloodhound-import analysis</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.error { color: #b00; }
+.count { color: #666; font-weight: normal; }
+pre { background: #f6f6f6; padding: 8px; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>bloodhound-import analysis</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}} from {{.Database}}</p>
+<ul>
+{{- range .Results}}
+<li><a href="#{{.Name}}">{{title .}}</a> <span class="count">({{if .Error}}failed{{else}}{{len .Rows}}{{end}})</span></li>
+{{- end}}
+</ul>
+{{- range .Results}}
+<h2 id="{{.Name}}">{{title .}} <span class="count">({{len .Rows}} rows)</span></h2>
+{{- if .Description}}
+<p>{{.Description}}</p>
+{{- end}}
+<details><summary>query</summary><pre>{{.Query}}</pre></details>
+{{- if .Error}}
+<p class="error">{{.Error}}</p>
+{{- else if .Rows}}
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{format .}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>no results</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+func (r *analysisReport) writeHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// queryNames returns sorted names of queries
+func queryNames(queries []analysisQuery) []string {
+	names := make([]string, 0, len(queries))
+	for _, q := range queries {
+		names = append(names, q.Name)
+	}
+	sort.Strings(names)
+	return names
+}
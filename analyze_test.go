@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func Test_loadAnalysisQueries(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "queries.yaml")
+	if err := ioutil.WriteFile(yamlFile, []byte(`
+- name: domain-admins
+  title: Domain Admins
+  query: |
+    MATCH (n)-[:MemberOf]->(g:Group {name: 'DOMAIN ADMINS@TESTLAB.LOCAL'}) RETURN n.name
+- name: stale-computers
+  query: MATCH (c:Computer) WHERE c.lastlogontimestamp < 1600000000 RETURN c.name
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "queries.json")
+	if err := ioutil.WriteFile(jsonFile, []byte(`[{"name": "owned", "query": "MATCH (n {owned: true}) RETURN n.name"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalidFile := filepath.Join(dir, "invalid.yaml")
+	if err := ioutil.WriteFile(invalidFile, []byte(`[{"name": "../owned", "query": "MATCH (n) RETURN n"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		files   []string
+		builtin bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "builtin", builtin: true, want: queryNamesInOrder(builtinQueries)},
+		{name: "files only", files: []string{yamlFile, jsonFile}, want: []string{"domain-admins", "stale-computers", "owned"}},
+		{name: "override builtin", files: []string{yamlFile}, builtin: true, want: append(queryNamesInOrder(builtinQueries), "stale-computers")},
+		{name: "invalid name", files: []string{invalidFile}, wantErr: true},
+		{name: "missing file", files: []string{filepath.Join(dir, "missing.yaml")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadAnalysisQueries(tt.files, tt.builtin)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadAnalysisQueries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, queryNamesInOrder(got)); diff != "" {
+				t.Errorf("loadAnalysisQueries() mismatch (-want got):\n%s", diff)
+			}
+			for _, q := range got {
+				if q.Name == "domain-admins" && len(tt.files) > 0 && q.Title != "Domain Admins" {
+					t.Errorf("built-in query wasn't replaced, got %+v", q)
+				}
+			}
+		})
+	}
+}
+
+func Test_builtinQueries(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, q := range builtinQueries {
+		if !queryNameRegex.MatchString(q.Name) || seen[q.Name] {
+			t.Errorf("invalid or duplicate query name %q", q.Name)
+		}
+		seen[q.Name] = true
+		if q.Title == "" || q.Description == "" || !strings.Contains(q.Query, "RETURN") {
+			t.Errorf("query %q is incomplete", q.Name)
+		}
+	}
+}
+
+func queryNamesInOrder(queries []analysisQuery) []string {
+	var names []string
+	for _, q := range queries {
+		names = append(names, q.Name)
+	}
+	return names
+}
+
+func Test_reportValue(t *testing.T) {
+	user := neo4j.Node{Id: 1, Labels: []string{"Base", "User"}, Props: map[string]interface{}{"name": "USER@TESTLAB.LOCAL"}}
+	group := neo4j.Node{Id: 2, Labels: []string{"Base", "Group"}, Props: map[string]interface{}{"objectid": "S-1-5-21-1-512"}}
+	computer := neo4j.Node{Id: 3, Labels: []string{"Base", "Computer"}, Props: map[string]interface{}{}}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "node", value: user, want: "USER@TESTLAB.LOCAL"},
+		{name: "node without name", value: group, want: "S-1-5-21-1-512"},
+		{name: "path", value: neo4j.Path{
+			Nodes: []neo4j.Node{user, group, computer},
+			Relationships: []neo4j.Relationship{
+				{StartId: 1, EndId: 2, Type: "MemberOf"},
+				{StartId: 3, EndId: 2, Type: "HasSession"},
+			},
+		}, want: "(USER@TESTLAB.LOCAL)-[MemberOf]->(S-1-5-21-1-512)<-[HasSession]-(3)"},
+		{name: "list", value: []interface{}{user, "GenericAll", int64(2)}, want: []interface{}{"USER@TESTLAB.LOCAL", "GenericAll", int64(2)}},
+		{name: "scalar", value: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, reportValue(tt.value)); diff != "" {
+				t.Errorf("reportValue() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_analysisReport_write(t *testing.T) {
+	report := &analysisReport{
+		Generated: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+		Database:  "bolt://localhost:7687",
+		Results: []*analysisResult{
+			{
+				analysisQuery: analysisQuery{Name: "dcsync-principals", Title: "DCSync principals", Query: "MATCH (n) RETURN n"},
+				Columns:       []string{"principal", "rights"},
+				Rows: [][]interface{}{
+					{"ADMIN@TESTLAB.LOCAL", []interface{}{"GetChanges", "GetChangesAll"}},
+					{"<script>@TESTLAB.LOCAL", nil},
+				},
+			},
+			{
+				analysisQuery: analysisQuery{Name: "broken", Query: "MATCH"},
+				Error:         "Neo.ClientError.Statement.SyntaxError",
+			},
+		},
+	}
+	dir := t.TempDir()
+	if err := report.write(dir, []string{reportJSON, reportCSV, reportHTML}); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "dcsync-principals.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "principal,rights\nADMIN@TESTLAB.LOCAL,\"GetChanges, GetChangesAll\"\n<script>@TESTLAB.LOCAL,\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("csv mismatch (-want got):\n%s", diff)
+	}
+	// failed queries don't have csv file
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "broken.csv")); err == nil {
+		t.Errorf("csv of failed query was written")
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(dir, "report.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"DCSync principals", "GetChanges, GetChangesAll", "&lt;script&gt;@TESTLAB.LOCAL", "Neo.ClientError.Statement.SyntaxError"} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("html report doesn't contain %q", s)
+		}
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"error": "Neo.ClientError.Statement.SyntaxError"`)) {
+		t.Errorf("json report doesn't contain error of failed query:\n%s", b)
+	}
+}
+
+func Test_runAnalysisQueries_lostConnection(t *testing.T) {
+	// nothing listens on the port once listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	driver, err := neo4j.NewDriver("bolt://"+l.Addr().String(), neo4j.NoAuth(), func(c *neo4j.Config) {
+		c.MaxTransactionRetryTime = 10 * time.Millisecond
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+
+	queries := []analysisQuery{{Name: "first"}, {Name: "lost", Query: "RETURN 1"}, {Name: "skipped"}}
+	var run []string
+	results, failed, err := runAnalysisQueries(context.Background(), queries, func(q analysisQuery) (*analysisResult, error) {
+		run = append(run, q.Name)
+		if q.Name == "lost" {
+			return runAnalysisQuery(context.Background(), driver, q, time.Second)
+		}
+		return &analysisResult{analysisQuery: q}, nil
+	})
+	if exitCode(err) != exitCodeConnectivity || failed != 1 {
+		t.Fatalf("runAnalysisQueries() error = %v, failed %d", err, failed)
+	}
+	if diff := cmp.Diff([]string{"first", "lost"}, run); diff != "" {
+		t.Errorf("run queries mismatch (-want got):\n%s", diff)
+	}
+	if len(results) != 2 || results[1].Name != "lost" || results[1].Error == "" {
+		t.Errorf("failed query isn't reported with its error, results = %+v", results)
+	}
+}
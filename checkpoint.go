@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpointFileName is created in target directory, it doesn't have '.json'
+// suffix so it is never picked up as input file
+const checkpointFileName = ".bloodhound-import.checkpoint"
+
+// checkpoint records last fully committed batch of every input file.
+// files are identified by hash of their content so renamed or moved files
+// are still recognised when import is resumed
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	// Batches is a map of file hash to index of last committed batch
+	Batches map[string]int `json:"batches"`
+}
+
+// loadCheckpoint returns checkpoint stored at path, if resume is false or
+// file doesn't exist an empty checkpoint is returned and any existing
+// progress will be overwritten on first commit
+func loadCheckpoint(path string, resume bool) (*checkpoint, error) {
+	cp := &checkpoint{path: path, Batches: map[string]int{}}
+	if !resume {
+		return cp, nil
+	}
+
+	b, err := ioutil.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	if cp.Batches == nil {
+		cp.Batches = map[string]int{}
+	}
+	return cp, nil
+}
+
+// lastBatch returns index of last committed batch of the file or -1 if
+// nothing was committed yet
+func (cp *checkpoint) lastBatch(hash string) int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if i, ok := cp.Batches[hash]; ok {
+		return i
+	}
+	return -1
+}
+
+// commit records batch as committed and persists checkpoint to disk
+func (cp *checkpoint) commit(hash string, index int) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if i, ok := cp.Batches[hash]; ok && i >= index {
+		return nil
+	}
+	cp.Batches[hash] = index
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	// write to temp file and rename so checkpoint is never left half written
+	tmp := cp.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+// clear removes checkpoint file once all inputs are loaded so a later run
+// with the same content, e.g. after the database was wiped, isn't skipped
+func (cp *checkpoint) clear() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.Batches = map[string]int{}
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), checkpointFileName)
+
+	cp, err := loadCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cp.lastBatch("abc"); got != -1 {
+		t.Errorf("lastBatch() on empty checkpoint = %d, want -1", got)
+	}
+	for _, i := range []int{0, 1, 2} {
+		if err := cp.commit("abc", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// out of order commit should not move checkpoint back
+	if err := cp.commit("abc", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := loadCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resumed.lastBatch("abc"); got != 2 {
+		t.Errorf("lastBatch() after resume = %d, want 2", got)
+	}
+
+	fresh, err := loadCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fresh.lastBatch("abc"); got != -1 {
+		t.Errorf("lastBatch() without resume = %d, want -1", got)
+	}
+
+	if err := resumed.clear(); err != nil {
+		t.Fatal(err)
+	}
+	cleared, err := loadCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cleared.lastBatch("abc"); got != -1 {
+		t.Errorf("lastBatch() after clear = %d, want -1", got)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAP_SERVER_SD_FLAGS_OID control, without it nTSecurityDescriptor is only
+// returned to privileged users. flags request owner and DACL
+const (
+	controlTypeSDFlags = "1.2.840.113556.1.4.801"
+	sdFlagsOwnerDACL   = 0x1 | 0x4
+)
+
+// collectorFilter selects objects imported as nodes and objects used to resolve
+// membership and trusts
+const collectorFilter = "(|(objectClass=domain)(objectClass=organizationalUnit)(objectClass=groupPolicyContainer)" +
+	"(objectClass=group)(objectClass=user)(objectClass=computer)(objectClass=trustedDomain))"
+
+// ldapConfig is configuration of LDAP collector
+type ldapConfig struct {
+	url                string
+	username           string
+	password           string
+	baseDN             string
+	insecureSkipVerify bool
+	startTLS           bool
+	allowInsecureBind  bool
+	pageSize           uint32
+}
+
+// validate checks that bind password isn't sent in cleartext, simple bind over
+// plain 'ldap://' is only allowed with StartTLS or if explicitly allowed
+func (cfg ldapConfig) validate() error {
+	u, err := url.Parse(cfg.url)
+	if err != nil {
+		return fmt.Errorf("invalid ldap url %w", err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	switch {
+	case scheme != "ldap" && scheme != "ldaps" && scheme != "ldapi":
+		return fmt.Errorf("unsupported ldap url scheme %q", u.Scheme)
+	case cfg.startTLS && scheme != "ldap":
+		return fmt.Errorf("StartTLS can only be used with 'ldap://' url")
+	case cfg.username != "" && scheme == "ldap" && !cfg.startTLS && !cfg.allowInsecureBind:
+		return fmt.Errorf("refusing to send bind password over unencrypted connection, use 'ldaps://' url, StartTLS or allow insecure bind")
+	}
+	return nil
+}
+
+// tlsConfig returns TLS config of ldaps and StartTLS connections
+func (cfg ldapConfig) tlsConfig() *tls.Config {
+	var serverName string
+	if u, err := url.Parse(cfg.url); err == nil {
+		serverName = u.Hostname()
+	}
+	return &tls.Config{ServerName: serverName, InsecureSkipVerify: cfg.insecureSkipVerify}
+}
+
+// ldapSearcher is subset of ldap.Client used by collector
+type ldapSearcher interface {
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	SearchWithPaging(req *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error)
+}
+
+// sdFlagsControl is LDAP_SERVER_SD_FLAGS_OID control
+type sdFlagsControl struct {
+	flags int64
+}
+
+func (c *sdFlagsControl) GetControlType() string {
+	return controlTypeSDFlags
+}
+
+func (c *sdFlagsControl) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, controlTypeSDFlags, "Control Type (SD Flags)"))
+	packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "Criticality"))
+
+	value := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, nil, "Control Value (SD Flags)")
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SD Flags Request Value")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.flags, "Flags"))
+	value.AppendChild(seq)
+	packet.AppendChild(value)
+	return packet
+}
+
+func (c *sdFlagsControl) String() string {
+	return fmt.Sprintf("Control Type: SD Flags (%q)  Criticality: true  Flags: %d", controlTypeSDFlags, c.flags)
+}
+
+// collectLDAP collects directory objects over LDAP and converts them to Bloodhound data,
+// returned hash identifies collected content so unchanged directory can be resumed
+func collectLDAP(ctx context.Context, cfg ldapConfig) (*bloodHoundRawData, string, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, "", err
+	}
+	conn, err := ldap.DialURL(cfg.url, ldap.DialWithTLSConfig(cfg.tlsConfig()))
+	if err != nil {
+		return nil, "", connectivityError(fmt.Errorf("unable to connect to %s %w", cfg.url, err))
+	}
+	defer conn.Close()
+
+	if cfg.startTLS {
+		if err := conn.StartTLS(cfg.tlsConfig()); err != nil {
+			return nil, "", connectivityError(fmt.Errorf("unable to start TLS with %s %w", cfg.url, err))
+		}
+	}
+
+	if cfg.username != "" {
+		if err := conn.Bind(cfg.username, cfg.password); err != nil {
+			return nil, "", connectivityError(fmt.Errorf("unable to bind as %s %w", cfg.username, err))
+		}
+	}
+
+	// searches can't be cancelled, closing connection aborts them
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	c := &ldapCollector{conn: conn, baseDN: cfg.baseDN, pageSize: cfg.pageSize}
+	entries, err := c.collect(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		return nil, "", err
+	}
+	return convertDirectory(entries, nil), hashEntries(entries), nil
+}
+
+// ldapCollector searches directory for objects of a domain
+type ldapCollector struct {
+	conn     ldapSearcher
+	baseDN   string
+	pageSize uint32
+}
+
+// collect returns objects of the domain and LAPS attributes of the schema,
+// base DN and schema are read from RootDSE
+func (c *ldapCollector) collect(ctx context.Context) ([]*directoryEntry, error) {
+	rootDSE, err := c.conn.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"defaultNamingContext", "schemaNamingContext"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read RootDSE %w", err)
+	}
+	var defaultNC, schemaNC string
+	if len(rootDSE.Entries) > 0 {
+		defaultNC = rootDSE.Entries[0].GetAttributeValue("defaultNamingContext")
+		schemaNC = rootDSE.Entries[0].GetAttributeValue("schemaNamingContext")
+	}
+	baseDN := c.baseDN
+	if baseDN == "" {
+		baseDN = defaultNC
+	}
+	if baseDN == "" {
+		return nil, fmt.Errorf("base DN isn't set and RootDSE has no defaultNamingContext")
+	}
+
+	log.WithField("base_dn", baseDN).Info("collecting objects over LDAP")
+	res, err := c.conn.SearchWithPaging(ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		collectorFilter, []string{"*", "nTSecurityDescriptor"}, []ldap.Control{&sdFlagsControl{flags: sdFlagsOwnerDACL}}), c.pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to search %s %w", baseDN, err)
+	}
+
+	entries := make([]*directoryEntry, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		de, err := c.entry(e)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, de)
+	}
+	log.WithField("base_dn", baseDN).Infof("collected %d objects", len(entries))
+
+	// LAPS attributes are optional, collection continues without them
+	if schemaNC != "" {
+		res, err := c.conn.Search(ldap.NewSearchRequest(schemaNC, ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
+			"(|(lDAPDisplayName=ms-Mcs-AdmPwd)(lDAPDisplayName=msLAPS-Password)(lDAPDisplayName=msLAPS-EncryptedPassword))",
+			[]string{"objectClass", "lDAPDisplayName", "schemaIDGUID"}, nil))
+		if err != nil {
+			log.WithError(err).Warn("unable to read LAPS attributes from schema")
+		} else {
+			for _, e := range res.Entries {
+				de, _ := c.entry(e)
+				entries = append(entries, de)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// entry converts LDAP entry to directory entry, attributes returned in ranges
+// (e.g. 'member;range=0-1499' of large groups) are read completely
+func (c *ldapCollector) entry(e *ldap.Entry) (*directoryEntry, error) {
+	de := newDirectoryEntry(e.DN)
+	for _, a := range e.Attributes {
+		name, next, ranged := parseRangeOption(a.Name)
+		for _, v := range a.ByteValues {
+			de.add(name, v)
+		}
+		for ranged {
+			var values [][]byte
+			var err error
+			values, next, ranged, err = c.attributeRange(e.DN, name, next)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range values {
+				de.add(name, v)
+			}
+		}
+	}
+	return de, nil
+}
+
+// attributeRange reads values of the attribute starting at given index
+func (c *ldapCollector) attributeRange(dn, name string, start int) ([][]byte, int, bool, error) {
+	attr := fmt.Sprintf("%s;range=%d-*", name, start)
+	res, err := c.conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{attr}, nil))
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("unable to read %s of %s %w", attr, dn, err)
+	}
+	if len(res.Entries) == 0 {
+		return nil, 0, false, nil
+	}
+	for _, a := range res.Entries[0].Attributes {
+		n, next, ranged := parseRangeOption(a.Name)
+		if strings.EqualFold(n, name) {
+			return a.ByteValues, next, ranged, nil
+		}
+	}
+	return nil, 0, false, nil
+}
+
+// parseRangeOption strips range option from attribute name and returns start
+// of the next range, ranged is false if option is missing or it's the last range
+func parseRangeOption(attr string) (name string, next int, ranged bool) {
+	i := strings.Index(strings.ToLower(attr), ";range=")
+	if i < 0 {
+		return attr, 0, false
+	}
+	name = attr[:i]
+	bounds := strings.SplitN(attr[i+len(";range="):], "-", 2)
+	if len(bounds) != 2 || bounds[1] == "*" {
+		return name, 0, false
+	}
+	end, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return name, 0, false
+	}
+	return name, end + 1, true
+}
+
+// hashEntries returns sha256 of entries content, it doesn't depend on order of entries
+// and values as servers don't guarantee it
+func hashEntries(entries []*directoryEntry) string {
+	sorted := append([]*directoryEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].dn < sorted[j].dn })
+
+	h := sha256.New()
+	for _, e := range sorted {
+		fmt.Fprintf(h, "dn:%s\n", e.dn)
+		names := make([]string, 0, len(e.attrs))
+		for name := range e.attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			values := append([][]byte(nil), e.attrs[name]...)
+			sort.Slice(values, func(i, j int) bool { return bytes.Compare(values[i], values[j]) < 0 })
+			for _, v := range values {
+				fmt.Fprintf(h, "%s:%x\n", name, v)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
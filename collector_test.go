@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/go-cmp/cmp"
+)
+
+// ldapStub implements ldapSearcher serving entries of test LDIF file
+type ldapStub struct {
+	rootDSE  *ldap.Entry
+	entries  []*ldap.Entry
+	ranges   map[string]*ldap.Entry
+	requests []*ldap.SearchRequest
+}
+
+func (s *ldapStub) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	s.requests = append(s.requests, req)
+	switch {
+	case req.BaseDN == "":
+		return &ldap.SearchResult{Entries: []*ldap.Entry{s.rootDSE}}, nil
+	case req.Scope == ldap.ScopeBaseObject:
+		if e, ok := s.ranges[req.BaseDN]; ok {
+			return &ldap.SearchResult{Entries: []*ldap.Entry{e}}, nil
+		}
+		return nil, ldap.NewError(ldap.LDAPResultNoSuchObject, nil)
+	}
+	return &ldap.SearchResult{}, nil
+}
+
+func (s *ldapStub) SearchWithPaging(req *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	s.requests = append(s.requests, req)
+	return &ldap.SearchResult{Entries: s.entries}, nil
+}
+
+func newLDAPStub(t *testing.T) *ldapStub {
+	t.Helper()
+	f, err := os.Open("test_data/testlab.ldif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries, err := readLDIF(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ldapStub{
+		rootDSE: &ldap.Entry{Attributes: []*ldap.EntryAttribute{
+			{Name: "defaultNamingContext", Values: []string{"DC=testlab,DC=local"}},
+			{Name: "schemaNamingContext", Values: []string{"CN=Schema,CN=Configuration,DC=testlab,DC=local"}},
+		}},
+		ranges: make(map[string]*ldap.Entry),
+	}
+	for _, e := range entries {
+		le := &ldap.Entry{DN: e.dn}
+		for name, values := range e.attrs {
+			if e.dn == "CN=Domain Admins,CN=Users,DC=testlab,DC=local" && name == "member" {
+				// large groups return members in ranges
+				name = "member;range=0-0"
+				s.ranges[e.dn] = &ldap.Entry{DN: e.dn, Attributes: []*ldap.EntryAttribute{
+					{Name: "member;range=1-*", ByteValues: [][]byte{[]byte("CN=svc_sql,CN=Users,DC=testlab,DC=local")}},
+				}}
+			}
+			le.Attributes = append(le.Attributes, &ldap.EntryAttribute{Name: name, ByteValues: values})
+		}
+		s.entries = append(s.entries, le)
+	}
+	return s
+}
+
+func Test_ldapCollector_collect(t *testing.T) {
+	stub := newLDAPStub(t)
+	c := &ldapCollector{conn: stub, pageSize: 100}
+
+	entries, err := c.collect(context.Background())
+	if err != nil {
+		t.Fatalf("collect() error = %v", err)
+	}
+
+	search := stub.requests[1]
+	if search.BaseDN != "DC=testlab,DC=local" || ldap.FindControl(search.Controls, controlTypeSDFlags) == nil {
+		t.Errorf("unexpected search request base %s controls %v", search.BaseDN, search.Controls)
+	}
+
+	data := convertDirectory(entries, nil)
+	if diff := cmp.Diff([]string{"domains", "ous", "gpos", "groups", "users", "computers"}, data.contentTypes()); diff != "" {
+		t.Errorf("contentTypes() mismatch (-want got):\n%s", diff)
+	}
+	domainSID := "S-1-5-21-883232822-274137685-4173207997"
+	wantMembers := []member{
+		{MemberID: domainSID + "-500", MemberType: "User"},
+		{MemberID: domainSID + "-1106", MemberType: "User"},
+	}
+	if diff := cmp.Diff(wantMembers, data.Groups[0].Members); diff != "" {
+		t.Errorf("ranged members mismatch (-want got):\n%s", diff)
+	}
+	if len(data.Domains[0].Trusts) != 1 {
+		t.Errorf("domain trusts = %v", data.Domains[0].Trusts)
+	}
+
+	// servers don't guarantee order of entries and values
+	reordered := make([]*directoryEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := newDirectoryEntry(entries[i].dn)
+		for name, values := range entries[i].attrs {
+			for j := len(values) - 1; j >= 0; j-- {
+				e.add(name, values[j])
+			}
+		}
+		reordered = append(reordered, e)
+	}
+	if hashEntries(entries) != hashEntries(reordered) {
+		t.Error("hashEntries() depends on order of entries")
+	}
+	changed := append([]*directoryEntry{newDirectoryEntry("CN=new,DC=testlab,DC=local")}, entries...)
+	if hashEntries(entries) == hashEntries(changed) {
+		t.Error("hashEntries() doesn't change with content")
+	}
+}
+
+// ldapStandIn is LDAP server stand-in serving ldapStub over the network,
+// it supports simple bind, StartTLS and search operations
+type ldapStandIn struct {
+	mu       sync.Mutex
+	stub     *ldapStub
+	password string
+	tls      *tls.Config
+	// binds records bind names and whether connection was encrypted
+	binds map[string]bool
+}
+
+func newLDAPStandIn(t *testing.T, stub *ldapStub, password string) (*ldapStandIn, string) {
+	t.Helper()
+	// borrow self-signed certificate of httptest server for StartTLS
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	cfg := ts.TLS.Clone()
+	ts.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &ldapStandIn{stub: stub, password: password, tls: cfg, binds: map[string]bool{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, "ldap://" + l.Addr().String()
+}
+
+func (s *ldapStandIn) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	encrypted := false
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id, _ := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, _ := op.Children[1].Value.(string)
+			code := uint16(ldap.LDAPResultSuccess)
+			if name != "" && op.Children[2].Data.String() != s.password {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			s.mu.Lock()
+			s.binds[name] = encrypted
+			s.mu.Unlock()
+			writeLDAPResponse(conn, id, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationExtendedRequest:
+			writeLDAPResponse(conn, id, ldapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, encrypted = tlsConn, true
+		case ldap.ApplicationSearchRequest:
+			s.search(conn, id, p)
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapStandIn) search(conn net.Conn, id int64, p *ber.Packet) {
+	op := p.Children[1]
+	req := &ldap.SearchRequest{}
+	req.BaseDN, _ = op.Children[0].Value.(string)
+	scope, _ := op.Children[1].Value.(int64)
+	req.Scope = int(scope)
+	for _, a := range op.Children[7].Children {
+		req.Attributes = append(req.Attributes, a.Data.String())
+	}
+	if len(p.Children) > 2 {
+		for _, c := range p.Children[2].Children {
+			if control, err := ldap.DecodeControl(c); err == nil {
+				req.Controls = append(req.Controls, control)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	var res *ldap.SearchResult
+	var err error
+	if req.Scope == ldap.ScopeWholeSubtree {
+		res, err = s.stub.SearchWithPaging(req, 0)
+	} else {
+		res, err = s.stub.Search(req)
+	}
+	s.mu.Unlock()
+
+	code := uint16(ldap.LDAPResultSuccess)
+	var le *ldap.Error
+	if errors.As(err, &le) {
+		code = le.ResultCode
+	}
+	if res != nil {
+		for _, e := range res.Entries {
+			entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+			entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+			attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+			for _, a := range e.Attributes {
+				attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+				attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "Type"))
+				values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+				for _, v := range a.Values {
+					values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+				}
+				for _, v := range a.ByteValues {
+					values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(v), "Value"))
+				}
+				attr.AppendChild(values)
+				attrs.AppendChild(attr)
+			}
+			entry.AppendChild(attrs)
+			writeLDAPResponse(conn, id, entry)
+		}
+	}
+	writeLDAPResponse(conn, id, ldapResult(ldap.ApplicationSearchResultDone, code))
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return res
+}
+
+func writeLDAPResponse(conn net.Conn, id int64, op *ber.Packet) {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	p.AppendChild(op)
+	conn.Write(p.Bytes())
+}
+
+func Test_collectLDAP(t *testing.T) {
+	srv, url := newLDAPStandIn(t, newLDAPStub(t), "P@ssw0rd")
+	username := "collector@testlab.local"
+
+	tests := []struct {
+		name          string
+		cfg           ldapConfig
+		wantEncrypted bool
+		wantCode      int
+	}{
+		{
+			name: "start tls",
+			cfg:  ldapConfig{url: url, username: username, password: "P@ssw0rd", startTLS: true, insecureSkipVerify: true},
+			// bind must happen after the connection is upgraded
+			wantEncrypted: true,
+		},
+		{
+			name: "allowed insecure bind",
+			cfg:  ldapConfig{url: url, username: username, password: "P@ssw0rd", allowInsecureBind: true},
+		},
+		{
+			name:     "invalid credentials",
+			cfg:      ldapConfig{url: url, username: username, password: "wrong", allowInsecureBind: true},
+			wantCode: exitCodeConnectivity,
+		},
+		{
+			name:     "untrusted certificate",
+			cfg:      ldapConfig{url: url, username: username, password: "P@ssw0rd", startTLS: true},
+			wantCode: exitCodeConnectivity,
+		},
+		{
+			name:     "cleartext bind",
+			cfg:      ldapConfig{url: url, username: username, password: "P@ssw0rd"},
+			wantCode: exitCodeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.mu.Lock()
+			srv.binds = map[string]bool{}
+			srv.mu.Unlock()
+
+			tt.cfg.pageSize = 100
+			data, hash, err := collectLDAP(context.Background(), tt.cfg)
+			if got := exitCode(err); got != tt.wantCode {
+				t.Fatalf("collectLDAP() error = %v, exit code %d, want %d", err, got, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if hash == "" || len(data.Groups) == 0 || len(data.Groups[0].Members) != 2 {
+				t.Errorf("collectLDAP() unexpected data, hash %q groups %v", hash, data.Groups)
+			}
+			srv.mu.Lock()
+			encrypted, ok := srv.binds[username]
+			srv.mu.Unlock()
+			if !ok || encrypted != tt.wantEncrypted {
+				t.Errorf("bind as %s recorded %t encrypted %t, want encrypted %t", username, ok, encrypted, tt.wantEncrypted)
+			}
+		})
+	}
+}
+
+func Test_ldapConfig_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ldapConfig
+		wantErr bool
+	}{
+		{name: "ldaps", cfg: ldapConfig{url: "ldaps://dc01.testlab.local", username: "u"}},
+		{name: "anonymous ldap", cfg: ldapConfig{url: "ldap://dc01.testlab.local"}},
+		{name: "ldap with start tls", cfg: ldapConfig{url: "ldap://dc01.testlab.local", username: "u", startTLS: true}},
+		{name: "ldap with insecure bind", cfg: ldapConfig{url: "ldap://dc01.testlab.local", username: "u", allowInsecureBind: true}},
+		{name: "ldap bind", cfg: ldapConfig{url: "LDAP://dc01.testlab.local", username: "u"}, wantErr: true},
+		{name: "ldaps with start tls", cfg: ldapConfig{url: "ldaps://dc01.testlab.local", startTLS: true}, wantErr: true},
+		{name: "unsupported scheme", cfg: ldapConfig{url: "http://dc01.testlab.local"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseRangeOption(t *testing.T) {
+	tests := []struct {
+		attr       string
+		wantName   string
+		wantNext   int
+		wantRanged bool
+	}{
+		{attr: "member", wantName: "member"},
+		{attr: "member;range=0-1499", wantName: "member", wantNext: 1500, wantRanged: true},
+		{attr: "member;Range=1500-*", wantName: "member"},
+		{attr: "member;range=0-x", wantName: "member"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.attr, func(t *testing.T) {
+			name, next, ranged := parseRangeOption(tt.attr)
+			if name != tt.wantName || next != tt.wantNext || ranged != tt.wantRanged {
+				t.Errorf("parseRangeOption() = %s, %d, %t want %s, %d, %t", name, next, ranged, tt.wantName, tt.wantNext, tt.wantRanged)
+			}
+		})
+	}
+}
+
+func Test_sdFlagsControl_Encode(t *testing.T) {
+	p := (&sdFlagsControl{flags: sdFlagsOwnerDACL}).Encode()
+	if len(p.Children) != 3 {
+		t.Fatalf("unexpected control %v", p.Children)
+	}
+	// SEQUENCE { INTEGER 5 }
+	if diff := cmp.Diff([]byte{0x30, 0x03, 0x02, 0x01, 0x05}, p.Children[2].Data.Bytes()); diff != "" {
+		t.Errorf("control value mismatch (-want got):\n%s", diff)
+	}
+}
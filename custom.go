@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// custom data types, they are imported after directory data
+const (
+	metaTypeNodes = "nodes"
+	metaTypeEdges = "edges"
+)
+
+// customNode is a node of custom data, nodes without objectid are matched by name
+// and only update existing nodes
+type customNode struct {
+	Label      string                 `json:"label"`
+	ObjectID   string                 `json:"objectid"`
+	Name       string                 `json:"name"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// customEndpoint is source or target of custom edge, it's matched by objectid
+// or by name. nodes matched by name must exist, nodes with objectid are created with label
+type customEndpoint struct {
+	ObjectID string `json:"objectid"`
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+}
+
+type customEdge struct {
+	Type       string                 `json:"type"`
+	Source     customEndpoint         `json:"source"`
+	Target     customEndpoint         `json:"target"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// validateCustom normalises custom nodes and edges and validates labels and edge types,
+// labels are written into cypher statements so only known labels are allowed
+func (data *bloodHoundRawData) validateCustom() error {
+	for i := range data.Nodes {
+		n := &data.Nodes[i]
+		label, ok := knownLabels[strings.ToLower(strings.TrimSpace(n.Label))]
+		if !ok {
+			return fmt.Errorf("node %d: unknown label %q", i+1, n.Label)
+		}
+		n.Label = label
+		n.ObjectID = strings.ToUpper(strings.TrimSpace(n.ObjectID))
+		n.Name = strings.ToUpper(strings.TrimSpace(n.Name))
+		if n.ObjectID == "" && n.Name == "" {
+			return fmt.Errorf("node %d: either objectid or name is required", i+1)
+		}
+		n.Properties = normaliseProperties(n.Properties)
+		if err := validateProperties(n.Properties); err != nil {
+			return fmt.Errorf("node %d: %w", i+1, err)
+		}
+	}
+	for i := range data.Edges {
+		e := &data.Edges[i]
+		if !cypherIdentifierRegex.MatchString(e.Type) {
+			return fmt.Errorf("edge %d: invalid type %q, only letters, digits and '_' are allowed", i+1, e.Type)
+		}
+		for _, ep := range []*customEndpoint{&e.Source, &e.Target} {
+			if err := ep.validate(); err != nil {
+				return fmt.Errorf("edge %d: %w", i+1, err)
+			}
+		}
+		e.Properties = normaliseProperties(e.Properties)
+		if err := validateProperties(e.Properties); err != nil {
+			return fmt.Errorf("edge %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validateProperties checks that properties can be stored by neo4j, values must be
+// primitives or lists of primitives of the same type. invalid value would fail the whole batch
+func validateProperties(props map[string]interface{}) error {
+	for k, v := range props {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			return fmt.Errorf("property %q: nested objects aren't supported", k)
+		case []interface{}:
+			for _, e := range v {
+				switch e.(type) {
+				case string, bool, float64, json.Number:
+				default:
+					return fmt.Errorf("property %q: lists can only contain strings, numbers or booleans", k)
+				}
+				if fmt.Sprintf("%T", e) != fmt.Sprintf("%T", v[0]) {
+					return fmt.Errorf("property %q: lists can't mix types", k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (ep *customEndpoint) validate() error {
+	label := "Base"
+	if ep.Label != "" {
+		var ok bool
+		if label, ok = knownLabels[strings.ToLower(strings.TrimSpace(ep.Label))]; !ok {
+			return fmt.Errorf("unknown label %q", ep.Label)
+		}
+	}
+	ep.Label = label
+	ep.ObjectID = strings.ToUpper(strings.TrimSpace(ep.ObjectID))
+	ep.Name = strings.ToUpper(strings.TrimSpace(ep.Name))
+	if ep.ObjectID == "" && ep.Name == "" {
+		return fmt.Errorf("either objectid or name of source and target is required")
+	}
+	return nil
+}
+
+// key returns value the endpoint is matched by
+func (ep customEndpoint) key() string {
+	if ep.ObjectID != "" {
+		return ep.ObjectID
+	}
+	return ep.Name
+}
+
+// matchedByName is returned by statements which match nodes by name, rows without
+// matching node aren't uploaded and the uploader warns about them. rows are counted by
+// their index in the list so duplicate rows and names matching multiple nodes are counted once each
+const matchedByName = " RETURN count(DISTINCT item.row) AS matched"
+
+// addRow appends item to the list of the statement, items of statements matching
+// nodes by name get their index in the list as 'row'
+func addRow(cyphers map[string]*cypher, st string, item map[string]interface{}) {
+	if _, ok := cyphers[hash(st)]; !ok {
+		cyphers[hash(st)] = &cypher{statement: st}
+	}
+	c := cyphers[hash(st)]
+	if strings.HasSuffix(st, matchedByName) {
+		item["row"] = len(c.list)
+	}
+	c.list = append(c.list, item)
+}
+
+// buildNodeByNameStatement returns statement which updates existing nodes matched by name
+func buildNodeByNameStatement(label string) string {
+	return fmt.Sprintf(`UNWIND $list AS item MATCH (n:Base {name: item.name}) SET n:%s SET n += item.properties`, label) + matchedByName
+}
+
+func buildCustomNodeCyphers(nodes []customNode) map[string]*cypher {
+	cyphers := make(map[string]*cypher)
+	for _, n := range nodes {
+		props := make(map[string]interface{}, len(n.Properties)+1)
+		for k, v := range n.Properties {
+			props[k] = v
+		}
+		if n.Name != "" {
+			props["name"] = n.Name
+		}
+		props = nodeProperties(n.Label, props)
+
+		var st string
+		item := map[string]interface{}{"properties": props}
+		if n.ObjectID != "" {
+			st = buildNodeStatement(n.Label)
+			item["objectid"] = n.ObjectID
+		} else {
+			st = buildNodeByNameStatement(n.Label)
+			item["name"] = n.Name
+		}
+		addRow(cyphers, st, item)
+	}
+	return cyphers
+}
+
+// buildCustomEdgeStatement returns statement of custom edge, nodes matched by name are matched
+// first as cypher doesn't allow MATCH after MERGE
+func buildCustomEdgeStatement(edgeType string, source, target customEndpoint) string {
+	var match, merge []string
+	for _, ep := range []struct {
+		v    string
+		item string
+		e    customEndpoint
+	}{{"n", "source", source}, {"m", "target", target}} {
+		if ep.e.ObjectID != "" {
+			merge = append(merge, fmt.Sprintf("MERGE (%s:Base {objectid: item.%s}) ON CREATE SET %s:%s", ep.v, ep.item, ep.v, ep.e.Label))
+		} else {
+			match = append(match, fmt.Sprintf("MATCH (%s:Base {name: item.%s})", ep.v, ep.item))
+		}
+	}
+	clauses := append(append([]string{"UNWIND $list AS item"}, match...), merge...)
+	statement := strings.Join(clauses, " ") + fmt.Sprintf(" MERGE (n)-[r:%s]->(m) SET r += item.properties", edgeType)
+	if len(match) > 0 {
+		statement += matchedByName
+	}
+	return statement
+}
+
+func buildCustomEdgeCyphers(edges []customEdge) map[string]*cypher {
+	cyphers := make(map[string]*cypher)
+	for _, e := range edges {
+		st := buildCustomEdgeStatement(e.Type, e.Source, e.Target)
+		props := edgeProperties(e.Type, e.Properties)
+		addRow(cyphers, st, map[string]interface{}{
+			"source":     e.Source.key(),
+			"target":     e.Target.key(),
+			"properties": props,
+		})
+	}
+	return cyphers
+}
+
+// columns of custom csv files, other columns are properties
+var (
+	customNodeColumns = []string{"label", "objectid", "name"}
+	customEdgeColumns = []string{"type", "source_objectid", "source_name", "source_label", "target_objectid", "target_name", "target_label"}
+)
+
+// parseCustomCSV parses csv file of custom nodes or edges, file with any 'source_*' or 'target_*'
+// column contains edges, so nodes can have 'type' property.
+// values are strings, properties known to the schema are converted when nodes are built.
+// empty values aren't written. it also returns sha256 hash of the content
+func parseCustomCSV(r io.Reader) (*bloodHoundRawData, string, error) {
+	h := sha256.New()
+	cr := csv.NewReader(io.TeeReader(r, h))
+	header, err := cr.Read()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read csv header %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+	edges := false
+	for _, col := range header {
+		edges = edges || (col != "type" && contains(customEdgeColumns, col))
+	}
+
+	data := &bloodHoundRawData{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		values := map[string]string{}
+		props := map[string]interface{}{}
+		for i, v := range record {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			values[header[i]] = v
+			if (edges && !contains(customEdgeColumns, header[i])) || (!edges && !contains(customNodeColumns, header[i])) {
+				props[header[i]] = v
+			}
+		}
+		if edges {
+			data.Edges = append(data.Edges, customEdge{
+				Type:       values["type"],
+				Source:     customEndpoint{ObjectID: values["source_objectid"], Name: values["source_name"], Label: values["source_label"]},
+				Target:     customEndpoint{ObjectID: values["target_objectid"], Name: values["target_name"], Label: values["target_label"]},
+				Properties: props,
+			})
+		} else {
+			data.Nodes = append(data.Nodes, customNode{Label: values["label"], ObjectID: values["objectid"], Name: values["name"], Properties: props})
+		}
+	}
+	if err := data.validateCustom(); err != nil {
+		return nil, "", err
+	}
+	return data, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isCustomType returns whether meta type is custom data
+func isCustomType(metaType string) bool {
+	return metaType == metaTypeNodes || metaType == metaTypeEdges
+}
+
+// inputBarrier holds custom data back until directory data of all inputs is queued. inputs are
+// processed concurrently, as the single uploader commits batches in order nodes matched by name
+// are uploaded before custom data which refers to them
+type inputBarrier struct {
+	mu      sync.Mutex
+	pending map[string]bool
+	done    chan struct{}
+}
+
+func newInputBarrier(inputs []string) *inputBarrier {
+	b := &inputBarrier{pending: make(map[string]bool), done: make(chan struct{})}
+	for _, i := range inputs {
+		b.pending[i] = true
+	}
+	if len(b.pending) == 0 {
+		close(b.done)
+	}
+	return b
+}
+
+// arrive records that directory data of the input is queued or that the input failed,
+// it can be called multiple times
+func (b *inputBarrier) arrive(input string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.pending[input] {
+		return
+	}
+	delete(b.pending, input)
+	if len(b.pending) == 0 {
+		close(b.done)
+	}
+}
+
+// wait blocks until all inputs arrived
+func (b *inputBarrier) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.done:
+		return nil
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseCustomCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    *bloodHoundRawData
+		wantErr bool
+	}{
+		{
+			name: "nodes",
+			csv:  "label,objectid,name,Description\ncomputer,vc-01,vcenter01.testlab.local,vCenter\nbase,,SCCM01.TESTLAB.LOCAL,\n",
+			want: &bloodHoundRawData{Nodes: []customNode{
+				{Label: "Computer", ObjectID: "VC-01", Name: "VCENTER01.TESTLAB.LOCAL", Properties: map[string]interface{}{"description": "vCenter"}},
+				{Label: "Base", Name: "SCCM01.TESTLAB.LOCAL", Properties: map[string]interface{}{}},
+			}},
+		},
+		{
+			name: "edges",
+			csv:  "type,source_objectid,source_name,source_label,target_objectid,target_name,target_label,role\nSCCMAdmin,S-1-5-21-1-1105,,user,,sccm01.testlab.local,,Full Administrator\n",
+			want: &bloodHoundRawData{Edges: []customEdge{{
+				Type:       "SCCMAdmin",
+				Source:     customEndpoint{ObjectID: "S-1-5-21-1-1105", Label: "User"},
+				Target:     customEndpoint{Name: "SCCM01.TESTLAB.LOCAL", Label: "Base"},
+				Properties: map[string]interface{}{"role": "Full Administrator"},
+			}}},
+		},
+		{
+			name: "nodes with type property",
+			csv:  "label,name,type\nComputer,esx01.testlab.local,hypervisor\n",
+			want: &bloodHoundRawData{Nodes: []customNode{
+				{Label: "Computer", Name: "ESX01.TESTLAB.LOCAL", Properties: map[string]interface{}{"type": "hypervisor"}},
+			}},
+		},
+		{name: "unknown label", csv: "label,objectid\nvCenter,VC-01\n", wantErr: true},
+		{name: "node without key", csv: "label,objectid,description\nComputer,,vCenter\n", wantErr: true},
+		{name: "invalid edge type", csv: "type,source_objectid,target_objectid\nAdmin]->(m) DETACH DELETE m //,A,B\n", wantErr: true},
+		{name: "edge without target", csv: "type,source_objectid\nSCCMAdmin,S-1-5-21-1-1105\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := parseCustomCSV(strings.NewReader(tt.csv))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCustomCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseCustomCSV() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parseData_custom(t *testing.T) {
+	data, _, err := parseData(strings.NewReader(`{
+		"nodes": [{"label": "Computer", "objectid": "vc-01", "name": "vcenter01.testlab.local", "properties": {"Enabled": "true"}}],
+		"edges": [
+			{"type": "PasswordReuse", "source": {"name": "alice@testlab.local"}, "target": {"objectid": "S-1-5-21-1-1106", "label": "User"}},
+			{"type": "PasswordReuse", "source": {"name": "alice@testlab.local"}, "target": {"objectid": "S-1-5-21-1-1106", "label": "User"}}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("parseData() error = %v", err)
+	}
+	types, _, err := dataTypes("reuse.json", data)
+	if err != nil {
+		t.Fatalf("dataTypes() error = %v", err)
+	}
+	if diff := cmp.Diff([]string{metaTypeNodes, metaTypeEdges}, types); diff != "" {
+		t.Errorf("dataTypes() mismatch (-want got):\n%s", diff)
+	}
+
+	node := buildNodeStatement("Computer")
+	edge := "UNWIND $list AS item MATCH (n:Base {name: item.source}) MERGE (m:Base {objectid: item.target}) ON CREATE SET m:User" +
+		" MERGE (n)-[r:PasswordReuse]->(m) SET r += item.properties RETURN count(DISTINCT item.row) AS matched"
+	want := map[string]*cypher{
+		hash(node): {statement: node, list: []map[string]interface{}{
+			{"objectid": "VC-01", "properties": map[string]interface{}{"name": "VCENTER01.TESTLAB.LOCAL", "enabled": true}},
+		}},
+		hash(edge): {statement: edge, list: []map[string]interface{}{
+			{"source": "ALICE@TESTLAB.LOCAL", "target": "S-1-5-21-1-1106", "properties": map[string]interface{}{}, "row": 0},
+			{"source": "ALICE@TESTLAB.LOCAL", "target": "S-1-5-21-1-1106", "properties": map[string]interface{}{}, "row": 1},
+		}},
+	}
+	got := buildCustomNodeCyphers(data.Nodes)
+	for k, v := range buildCustomEdgeCyphers(data.Edges) {
+		got[k] = v
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(cypher{})); diff != "" {
+		t.Errorf("custom cyphers mismatch (-want got):\n%s", diff)
+	}
+
+	for _, invalid := range []string{
+		`{"nodes": [{"label": "Server", "objectid": "SRV-01"}]}`,
+		`{"nodes": [{"label": "Computer", "objectid": "SRV-01", "properties": {"os": {"name": "ESXi"}}}]}`,
+		`{"edges": [{"type": "AdminTo", "source": {"objectid": "A"}, "target": {"objectid": "B"}, "properties": {"roles": [["admin"]]}}]}`,
+		`{"edges": [{"type": "AdminTo", "source": {"objectid": "A"}, "target": {"objectid": "B"}, "properties": {"ports": [443, "https"]}}]}`,
+	} {
+		if _, _, err := parseData(strings.NewReader(invalid)); err == nil {
+			t.Errorf("parseData(%s) expected error", invalid)
+		}
+	}
+}
+
+func Test_queueData_customAfterDirectory(t *testing.T) {
+	edges := &bloodHoundRawData{Edges: []customEdge{{Type: "PasswordReuse", Source: customEndpoint{Name: "ALICE@TESTLAB.LOCAL"}, Target: customEndpoint{Name: "BOB@TESTLAB.LOCAL"}}}}
+	users := &bloodHoundRawData{Users: []user{{ObjectIdentifier: "S-1-5-21-1-1105"}}}
+	inputs := []string{"reuse.json", "users.json", "failed.json"}
+
+	cypherChan := make(chan *batch, 10)
+	cp, _ := loadCheckpoint(filepath.Join(t.TempDir(), checkpointFileName), false)
+	summary := newImportSummary(inputs)
+	barrier := newInputBarrier(inputs)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- queueData(context.Background(), "reuse.json", "a", edges, []string{metaTypeEdges}, cypherChan, cp, summary, nil, barrier)
+	}()
+	// custom data isn't queued until all inputs arrive
+	select {
+	case b := <-cypherChan:
+		t.Fatalf("custom batch %s queued before directory data", b.metaType)
+	case <-time.After(50 * time.Millisecond):
+	}
+	go func() {
+		errs <- queueData(context.Background(), "users.json", "b", users, []string{"users"}, cypherChan, cp, summary, nil, barrier)
+	}()
+	barrier.arrive("failed.json")
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(cypherChan)
+
+	var got []string
+	for b := range cypherChan {
+		got = append(got, b.metaType)
+	}
+	if diff := cmp.Diff([]string{"users", metaTypeEdges}, got); diff != "" {
+		t.Errorf("queued batches mismatch (-want got):\n%s", diff)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	relSourceTypeRegex = regexp.MustCompile(`\{objectid: item\.source\}\) ON CREATE SET n:(\w+)`)
+	relTargetTypeRegex = regexp.MustCompile(`\{objectid: item\.target\}\) ON CREATE SET m:(\w+)`)
+)
+
+// graphNode is a node of graph snapshot compared by 'diff' command
+type graphNode struct {
+	label     string
+	name      string
+	highValue bool
+}
+
+// graphEdge is identified by objectids of its nodes and its type
+type graphEdge struct {
+	source   string
+	edgeType string
+	target   string
+}
+
+// graphSnapshot is in memory copy of nodes and edges of an import run
+type graphSnapshot struct {
+	nodes map[string]*graphNode
+	edges map[graphEdge]bool
+}
+
+func newGraphSnapshot() *graphSnapshot {
+	return &graphSnapshot{
+		nodes: make(map[string]*graphNode),
+		edges: make(map[graphEdge]bool),
+	}
+}
+
+// node returns node with given objectid, node is added if it doesn't exist
+func (g *graphSnapshot) node(id string) *graphNode {
+	n, ok := g.nodes[id]
+	if !ok {
+		n = &graphNode{}
+		g.nodes[id] = n
+	}
+	return n
+}
+
+// name returns name of the node, objectid is used for nodes without name
+func (g *graphSnapshot) name(id string) string {
+	if n, ok := g.nodes[id]; ok && n.name != "" {
+		return n.name
+	}
+	return id
+}
+
+// addCyphers adds nodes and edges which cyphers would create in neo4j, labels of relationship
+// nodes are only used if node isn't created by node statement, same as 'ON CREATE SET'
+func (g *graphSnapshot) addCyphers(cyphers map[string]*cypher) {
+	for _, c := range cyphers {
+		if m := relTypeRegex.FindStringSubmatch(c.statement); m != nil {
+			sourceType, targetType := "", ""
+			if t := relSourceTypeRegex.FindStringSubmatch(c.statement); t != nil {
+				sourceType = t[1]
+			}
+			if t := relTargetTypeRegex.FindStringSubmatch(c.statement); t != nil {
+				targetType = t[1]
+			}
+			for _, item := range c.list {
+				source, _ := item["source"].(string)
+				target, _ := item["target"].(string)
+				if source == "" || target == "" {
+					continue
+				}
+				if n := g.node(source); n.label == "" {
+					n.label = sourceType
+				}
+				if n := g.node(target); n.label == "" {
+					n.label = targetType
+				}
+				g.edges[graphEdge{source: source, edgeType: m[1], target: target}] = true
+			}
+			continue
+		}
+		if m := nodeTypeRegex.FindStringSubmatch(c.statement); m != nil {
+			for _, item := range c.list {
+				id, _ := item["objectid"].(string)
+				if id == "" {
+					continue
+				}
+				n := g.node(id)
+				n.label = m[1]
+				props, _ := item["properties"].(map[string]interface{})
+				if name, ok := props["name"].(string); ok {
+					n.name = name
+				}
+				if hv, ok := props["highvalue"].(bool); ok {
+					n.highValue = hv
+				}
+			}
+		}
+	}
+}
+
+// loadFileSnapshot builds snapshot from Bloodhound json files the same way they are uploaded,
+// including memberships inherited from GPOs
+func loadFileSnapshot(files []string) (*graphSnapshot, error) {
+	g := newGraphSnapshot()
+	tree := newGPOTree()
+	for _, f := range files {
+		data, err := parseFile(f)
+		if err != nil {
+			return nil, parseError(fmt.Errorf("%s: %w", f, err))
+		}
+		types, _, err := dataTypes(f, data)
+		if err != nil {
+			return nil, parseError(fmt.Errorf("%s: %w", f, err))
+		}
+		tree.add(data, types)
+		for _, metaType := range types {
+			total, build := batchBuilder(data, metaType)
+			if total > 0 {
+				g.addCyphers(build(0, total))
+			}
+		}
+	}
+	g.addCyphers(tree.cyphers())
+	return g, nil
+}
+
+// loadDatabaseSnapshot reads all nodes and edges of neo4j database, empty name is the default database
+func loadDatabaseSnapshot(driver neo4j.Driver, database string) (*graphSnapshot, error) {
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeRead,
+		DatabaseName: database,
+	})
+	defer session.Close()
+
+	labels := make([]string, 0, len(knownLabels))
+	for _, l := range knownLabels {
+		labels = append(labels, l)
+	}
+
+	g := newGraphSnapshot()
+	_, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		result, err := tx.Run(`MATCH (n:Base)
+RETURN n.objectid AS objectid, head([l IN labels(n) WHERE l IN $labels AND l <> 'Base']) AS label,
+n.name AS name, coalesce(n.highvalue, false) AS highvalue`, map[string]interface{}{"labels": labels})
+		if err != nil {
+			return nil, err
+		}
+		for result.Next() {
+			values := result.Record().Values
+			id, _ := values[0].(string)
+			if id == "" {
+				continue
+			}
+			n := g.node(id)
+			n.label, _ = values[1].(string)
+			n.name, _ = values[2].(string)
+			n.highValue, _ = values[3].(bool)
+		}
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+
+		result, err = tx.Run(`MATCH (n:Base)-[r]->(m:Base) RETURN n.objectid, type(r), m.objectid`, nil)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next() {
+			values := result.Record().Values
+			e := graphEdge{}
+			e.source, _ = values[0].(string)
+			e.edgeType, _ = values[1].(string)
+			e.target, _ = values[2].(string)
+			if e.source != "" && e.target != "" {
+				g.edges[e] = true
+			}
+		}
+		return nil, result.Err()
+	})
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	return g, nil
+}
+
+// shortestPaths returns shortest path over attack edges from every user and computer to every
+// high value node, paths are keyed by source and target objectids and contain objectids of the nodes
+func (g *graphSnapshot) shortestPaths() map[[2]string][]graphEdge {
+	abusable := make(map[string]bool)
+	for _, e := range strings.Split(attackEdges, "|") {
+		abusable[e] = true
+	}
+	incoming := make(map[string][]graphEdge)
+	for e := range g.edges {
+		if abusable[e.edgeType] {
+			incoming[e.target] = append(incoming[e.target], e)
+		}
+	}
+	// edges are visited in the same order so paths are stable
+	for _, edges := range incoming {
+		sort.Slice(edges, func(i, j int) bool { return edgeLess(edges[i], edges[j]) })
+	}
+
+	paths := make(map[[2]string][]graphEdge)
+	for target, n := range g.nodes {
+		if !n.highValue {
+			continue
+		}
+		// breadth first search from target backwards, next is the edge towards the target
+		next := map[string]graphEdge{}
+		queue := []string{target}
+		visited := map[string]bool{target: true}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			for _, e := range incoming[id] {
+				if visited[e.source] {
+					continue
+				}
+				visited[e.source] = true
+				next[e.source] = e
+				queue = append(queue, e.source)
+			}
+		}
+		for source := range next {
+			s := g.nodes[source]
+			if s == nil || s.highValue || (s.label != "User" && s.label != "Computer") {
+				continue
+			}
+			var path []graphEdge
+			for id := source; id != target; {
+				e := next[id]
+				path = append(path, e)
+				id = e.target
+			}
+			paths[[2]string{source, target}] = path
+		}
+	}
+	return paths
+}
+
+func edgeLess(a, b graphEdge) bool {
+	if a.source != b.source {
+		return a.source < b.source
+	}
+	if a.edgeType != b.edgeType {
+		return a.edgeType < b.edgeType
+	}
+	return a.target < b.target
+}
+
+type diffNode struct {
+	ObjectID  string `json:"objectid"`
+	Label     string `json:"label"`
+	Name      string `json:"name"`
+	HighValue bool   `json:"highvalue"`
+}
+
+type diffEdge struct {
+	Source          string `json:"source"`
+	SourceName      string `json:"source_name"`
+	Type            string `json:"type"`
+	Target          string `json:"target"`
+	TargetName      string `json:"target_name"`
+	HighValueTarget bool   `json:"highvalue_target"`
+}
+
+type diffPath struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Hops   int    `json:"hops"`
+	Path   string `json:"path"`
+}
+
+// graphDiff is the result of 'diff' command, paths are shortest paths from users and computers
+// to high value targets which exist only in one of the snapshots
+type graphDiff struct {
+	Old          string     `json:"old"`
+	New          string     `json:"new"`
+	AddedNodes   []diffNode `json:"added_nodes"`
+	RemovedNodes []diffNode `json:"removed_nodes"`
+	AddedEdges   []diffEdge `json:"added_edges"`
+	RemovedEdges []diffEdge `json:"removed_edges"`
+	AddedPaths   []diffPath `json:"added_paths"`
+	RemovedPaths []diffPath `json:"removed_paths"`
+}
+
+// diffSnapshots compares snapshots of the old (before) and new (after) run, results are sorted so diff is stable
+func diffSnapshots(before, after *graphSnapshot) *graphDiff {
+	d := &graphDiff{
+		AddedNodes:   diffNodes(after, before),
+		RemovedNodes: diffNodes(before, after),
+		AddedEdges:   diffEdges(after, before),
+		RemovedEdges: diffEdges(before, after),
+	}
+	oldPaths, newPaths := before.shortestPaths(), after.shortestPaths()
+	d.AddedPaths = diffPaths(after, newPaths, oldPaths)
+	d.RemovedPaths = diffPaths(before, oldPaths, newPaths)
+	return d
+}
+
+// diffNodes returns nodes of a which aren't in b
+func diffNodes(a, b *graphSnapshot) []diffNode {
+	nodes := []diffNode{}
+	for id, n := range a.nodes {
+		if _, ok := b.nodes[id]; ok {
+			continue
+		}
+		nodes = append(nodes, diffNode{ObjectID: id, Label: n.label, Name: a.name(id), HighValue: n.highValue})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ObjectID < nodes[j].ObjectID })
+	return nodes
+}
+
+// diffEdges returns edges of a which aren't in b
+func diffEdges(a, b *graphSnapshot) []diffEdge {
+	var edges []graphEdge
+	for e := range a.edges {
+		if !b.edges[e] {
+			edges = append(edges, e)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return edgeLess(edges[i], edges[j]) })
+
+	result := make([]diffEdge, 0, len(edges))
+	for _, e := range edges {
+		result = append(result, diffEdge{
+			Source:          e.source,
+			SourceName:      a.name(e.source),
+			Type:            e.edgeType,
+			Target:          e.target,
+			TargetName:      a.name(e.target),
+			HighValueTarget: a.nodes[e.target] != nil && a.nodes[e.target].highValue,
+		})
+	}
+	return result
+}
+
+// diffPaths returns paths of a which aren't in b, ordered by number of hops
+func diffPaths(g *graphSnapshot, a, b map[[2]string][]graphEdge) []diffPath {
+	paths := []diffPath{}
+	for key, path := range a {
+		if _, ok := b[key]; ok {
+			continue
+		}
+		var sb strings.Builder
+		sb.WriteString("(" + g.name(key[0]) + ")")
+		for _, e := range path {
+			sb.WriteString("-[" + e.edgeType + "]->(" + g.name(e.target) + ")")
+		}
+		paths = append(paths, diffPath{Source: g.name(key[0]), Target: g.name(key[1]), Hops: len(path), Path: sb.String()})
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Hops != paths[j].Hops {
+			return paths[i].Hops < paths[j].Hops
+		}
+		if paths[i].Target != paths[j].Target {
+			return paths[i].Target < paths[j].Target
+		}
+		return paths[i].Source < paths[j].Source
+	})
+	return paths
+}
+
+func diffCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "diff",
+		Usage: "compare two import runs and report added and removed nodes, edges and paths to high value targets",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "bhi-old",
+				Usage: "json files or folders of the old run, folders are searched same way as '--bhi-target-directory'",
+			},
+			&cli.StringSliceFlag{
+				Name:  "bhi-new",
+				Usage: "json files or folders of the new run",
+			},
+			&cli.StringFlag{
+				Name:  "bhi-old-database",
+				Usage: "neo4j database with the old run, used instead of '--bhi-old'",
+			},
+			&cli.StringFlag{
+				Name:  "bhi-new-database",
+				Usage: "neo4j database with the new run, used instead of '--bhi-new'",
+			},
+			&cli.StringFlag{
+				Name:  "bhi-output",
+				Usage: "file the diff is written to as json, stdout is used if not set",
+			},
+		},
+		Action: diff,
+	}
+}
+
+func diff(c *cli.Context) error {
+	for _, side := range []string{"old", "new"} {
+		files, db := len(c.StringSlice("bhi-"+side)) > 0, c.String("bhi-"+side+"-database") != ""
+		if files == db {
+			return fmt.Errorf("either '--bhi-%s' or '--bhi-%s-database' must be specified", side, side)
+		}
+	}
+
+	var driver neo4j.Driver
+	if c.String("bhi-old-database") != "" || c.String("bhi-new-database") != "" {
+		var err error
+		if driver, err = connect(c); err != nil {
+			return err
+		}
+		defer driver.Close()
+	}
+
+	load := func(side string) (*graphSnapshot, string, error) {
+		if db := c.String("bhi-" + side + "-database"); db != "" {
+			log.Infof("loading %s run from database %s", side, db)
+			g, err := loadDatabaseSnapshot(driver, db)
+			return g, "database " + db, err
+		}
+		inputs := c.StringSlice("bhi-" + side)
+		files, err := findDiffInputs(c.Context, inputs, inputOptions{
+			recursive: c.Bool("bhi-recursive"),
+			include:   c.StringSlice("bhi-include"),
+			exclude:   c.StringSlice("bhi-exclude"),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		log.Infof("loading %s run from %d files", side, len(files))
+		g, err := loadFileSnapshot(files)
+		return g, strings.Join(inputs, ", "), err
+	}
+
+	before, oldName, err := load("old")
+	if err != nil {
+		return err
+	}
+	after, newName, err := load("new")
+	if err != nil {
+		return err
+	}
+
+	d := diffSnapshots(before, after)
+	d.Old, d.New = oldName, newName
+	log.WithField("added", len(d.AddedNodes)).WithField("removed", len(d.RemovedNodes)).Info("nodes")
+	log.WithField("added", len(d.AddedEdges)).WithField("removed", len(d.RemovedEdges)).Info("edges")
+	log.WithField("added", len(d.AddedPaths)).WithField("removed", len(d.RemovedPaths)).Info("paths to high value targets")
+
+	var w io.Writer = os.Stdout
+	if name := c.String("bhi-output"); name != "" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// findDiffInputs returns local json files of the inputs, folders are searched for input files
+func findDiffInputs(ctx context.Context, inputs []string, opts inputOptions) ([]string, error) {
+	var dirs, files []string
+	for _, in := range inputs {
+		info, err := os.Stat(in)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, in)
+		} else {
+			files = append(files, in)
+		}
+	}
+	return findInputFiles(ctx, &inputSource{}, dirs, files, opts)
+}
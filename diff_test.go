@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_diffSnapshots(t *testing.T) {
+	users := buildNodeStatement("User")
+	groups := buildNodeStatement("Group")
+	memberOf := buildRelStatement("User", "Group", "MemberOf", "{isacl:false}")
+	genericAll := buildACEStatement("User", "Group", "GenericAll")
+	sessions := buildRelStatement("Computer", "User", "HasSession", "{isacl:false}")
+
+	before := newGraphSnapshot()
+	before.addCyphers(map[string]*cypher{
+		hash(users): {statement: users, list: []map[string]interface{}{
+			{"objectid": "S-1-5-21-1-1105", "properties": map[string]interface{}{"name": "ALICE@TESTLAB.LOCAL"}},
+			{"objectid": "S-1-5-21-1-1106", "properties": map[string]interface{}{"name": "BOB@TESTLAB.LOCAL"}},
+		}},
+		hash(groups): {statement: groups, list: []map[string]interface{}{
+			{"objectid": "S-1-5-21-1-512", "properties": map[string]interface{}{"name": "DOMAIN ADMINS@TESTLAB.LOCAL", "highvalue": true}},
+		}},
+		hash(memberOf): {statement: memberOf, list: []map[string]interface{}{
+			{"source": "S-1-5-21-1-1106", "target": "S-1-5-21-1-512"},
+		}},
+	})
+
+	after := newGraphSnapshot()
+	after.addCyphers(map[string]*cypher{
+		hash(users): {statement: users, list: []map[string]interface{}{
+			{"objectid": "S-1-5-21-1-1105", "properties": map[string]interface{}{"name": "ALICE@TESTLAB.LOCAL"}},
+		}},
+		hash(groups): {statement: groups, list: []map[string]interface{}{
+			{"objectid": "S-1-5-21-1-512", "properties": map[string]interface{}{"name": "DOMAIN ADMINS@TESTLAB.LOCAL", "highvalue": true}},
+		}},
+		hash(genericAll): {statement: genericAll, list: []map[string]interface{}{
+			{"source": "S-1-5-21-1-1107", "target": "S-1-5-21-1-512", "isinherited": false},
+		}},
+		hash(sessions): {statement: sessions, list: []map[string]interface{}{
+			{"source": "S-1-5-21-1-1000", "target": "S-1-5-21-1-1107"},
+		}},
+	})
+
+	want := &graphDiff{
+		AddedNodes: []diffNode{
+			{ObjectID: "S-1-5-21-1-1000", Label: "Computer", Name: "S-1-5-21-1-1000"},
+			{ObjectID: "S-1-5-21-1-1107", Label: "User", Name: "S-1-5-21-1-1107"},
+		},
+		RemovedNodes: []diffNode{
+			{ObjectID: "S-1-5-21-1-1106", Label: "User", Name: "BOB@TESTLAB.LOCAL"},
+		},
+		AddedEdges: []diffEdge{
+			{Source: "S-1-5-21-1-1000", SourceName: "S-1-5-21-1-1000", Type: "HasSession", Target: "S-1-5-21-1-1107", TargetName: "S-1-5-21-1-1107"},
+			{Source: "S-1-5-21-1-1107", SourceName: "S-1-5-21-1-1107", Type: "GenericAll", Target: "S-1-5-21-1-512", TargetName: "DOMAIN ADMINS@TESTLAB.LOCAL", HighValueTarget: true},
+		},
+		RemovedEdges: []diffEdge{
+			{Source: "S-1-5-21-1-1106", SourceName: "BOB@TESTLAB.LOCAL", Type: "MemberOf", Target: "S-1-5-21-1-512", TargetName: "DOMAIN ADMINS@TESTLAB.LOCAL", HighValueTarget: true},
+		},
+		AddedPaths: []diffPath{
+			{Source: "S-1-5-21-1-1107", Target: "DOMAIN ADMINS@TESTLAB.LOCAL", Hops: 1, Path: "(S-1-5-21-1-1107)-[GenericAll]->(DOMAIN ADMINS@TESTLAB.LOCAL)"},
+			{Source: "S-1-5-21-1-1000", Target: "DOMAIN ADMINS@TESTLAB.LOCAL", Hops: 2,
+				Path: "(S-1-5-21-1-1000)-[HasSession]->(S-1-5-21-1-1107)-[GenericAll]->(DOMAIN ADMINS@TESTLAB.LOCAL)"},
+		},
+		RemovedPaths: []diffPath{
+			{Source: "BOB@TESTLAB.LOCAL", Target: "DOMAIN ADMINS@TESTLAB.LOCAL", Hops: 1, Path: "(BOB@TESTLAB.LOCAL)-[MemberOf]->(DOMAIN ADMINS@TESTLAB.LOCAL)"},
+		},
+	}
+	if diff := cmp.Diff(want, diffSnapshots(before, after)); diff != "" {
+		t.Errorf("diffSnapshots() mismatch (-want got):\n%s", diff)
+	}
+}
+
+func Test_loadFileSnapshot(t *testing.T) {
+	g, err := loadFileSnapshot([]string{"test_data/group.json", "test_data/user.json"})
+	if err != nil {
+		t.Fatalf("loadFileSnapshot() error = %v", err)
+	}
+	admin := "S-1-5-21-3130019616-2776909439-2417379446-500"
+	if n := g.nodes[admin]; n == nil || n.label != "User" || n.name != "ADMINISTRATOR@TESTLAB.LOCAL" {
+		t.Errorf("unexpected node %s: %+v", admin, n)
+	}
+	if !g.edges[graphEdge{source: admin, edgeType: "MemberOf", target: "S-1-5-21-3130019616-2776909439-2417379446-513"}] {
+		t.Errorf("primary group membership of %s is missing", admin)
+	}
+}
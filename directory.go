@@ -0,0 +1,921 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metaTypeAll is set as meta type of data converted from directory dumps
+// (ADExplorer snapshots, LDIF, LDAP), such data contains all object types
+const metaTypeAll = "all"
+
+// directoryEntry is a LDAP object with its attributes. attribute names are
+// lowercased, values are in LDAP string representation except binary
+// attributes (objectSid, objectGUID, nTSecurityDescriptor...) which are raw bytes
+type directoryEntry struct {
+	dn    string
+	attrs map[string][][]byte
+}
+
+func newDirectoryEntry(dn string) *directoryEntry {
+	return &directoryEntry{dn: dn, attrs: make(map[string][][]byte)}
+}
+
+func (e *directoryEntry) add(name string, value []byte) {
+	name = strings.ToLower(name)
+	e.attrs[name] = append(e.attrs[name], value)
+}
+
+// get returns first value of the attribute
+func (e *directoryEntry) get(name string) string {
+	if v := e.attrs[name]; len(v) > 0 {
+		return string(v[0])
+	}
+	return ""
+}
+
+// values returns all values of the attribute
+func (e *directoryEntry) values(name string) []string {
+	var values []string
+	for _, v := range e.attrs[name] {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+func (e *directoryEntry) raw(name string) []byte {
+	if v := e.attrs[name]; len(v) > 0 {
+		return v[0]
+	}
+	return nil
+}
+
+func (e *directoryEntry) int(name string) int64 {
+	i, _ := strconv.ParseInt(strings.TrimSpace(e.get(name)), 10, 64)
+	return i
+}
+
+func (e *directoryEntry) has(name string) bool {
+	return len(e.attrs[name]) > 0
+}
+
+func (e *directoryEntry) hasClass(class string) bool {
+	for _, c := range e.values("objectclass") {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// label returns node label of the entry or empty string if entry isn't imported
+func (e *directoryEntry) label() string {
+	switch {
+	case e.hasClass("domainDNS"):
+		return "Domain"
+	case e.hasClass("organizationalUnit"):
+		return "OU"
+	case e.hasClass("groupPolicyContainer"):
+		return "GPO"
+	case e.hasClass("group"):
+		return "Group"
+	case e.hasClass("msDS-GroupManagedServiceAccount"):
+		// gMSAs are imported as users same as SharpHound
+		return "User"
+	case e.hasClass("computer"):
+		return "Computer"
+	case e.hasClass("user") && !e.hasClass("foreignSecurityPrincipal"):
+		return "User"
+	}
+	return ""
+}
+
+// sidToString converts binary SID to its string form e.g. 'S-1-5-21-...'
+func sidToString(b []byte) (string, error) {
+	if len(b) < 8 {
+		return "", fmt.Errorf("invalid SID length %d", len(b))
+	}
+	count := int(b[1])
+	if len(b) < 8+4*count {
+		return "", fmt.Errorf("invalid SID length %d for %d sub authorities", len(b), count)
+	}
+	var authority uint64
+	for _, v := range b[2:8] {
+		authority = authority<<8 | uint64(v)
+	}
+	s := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 0; i < count; i++ {
+		s += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(b[8+4*i:]))
+	}
+	return s, nil
+}
+
+// guidToString converts binary GUID to its uppercase string form
+func guidToString(b []byte) (string, error) {
+	if len(b) != 16 {
+		return "", fmt.Errorf("invalid GUID length %d", len(b))
+	}
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10], b[10:16]), nil
+}
+
+// parseSID returns SID attribute value in string form, value can be binary
+// or already in string form as written by some LDAP export tools
+func parseSID(b []byte) (string, error) {
+	if bytes.HasPrefix(b, []byte("S-1-")) {
+		return string(b), nil
+	}
+	return sidToString(b)
+}
+
+// parseGUID returns GUID attribute value in uppercase string form, value can
+// be binary or already in string form with or without braces
+func parseGUID(b []byte) (string, error) {
+	if len(b) == 16 {
+		return guidToString(b)
+	}
+	guid := strings.ToUpper(strings.Trim(string(b), "{}"))
+	if len(guid) != 36 || strings.Count(guid, "-") != 4 {
+		return "", fmt.Errorf("invalid GUID %q", b)
+	}
+	return guid, nil
+}
+
+// splitDN splits DN into RDNs, escaped commas are not treated as separators
+func splitDN(dn string) []string {
+	var (
+		rdns    []string
+		current strings.Builder
+		escaped bool
+	)
+	for _, r := range dn {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ',':
+			rdns = append(rdns, strings.TrimSpace(current.String()))
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		rdns = append(rdns, strings.TrimSpace(current.String()))
+	}
+	return rdns
+}
+
+// parentDN returns DN of the parent object
+func parentDN(dn string) string {
+	rdns := splitDN(dn)
+	if len(rdns) < 2 {
+		return ""
+	}
+	return strings.Join(rdns[1:], ",")
+}
+
+// rdnValue returns value of the first RDN, e.g. 'Users' for 'CN=Users,DC=testlab,DC=local'
+func rdnValue(dn string) string {
+	rdns := splitDN(dn)
+	if len(rdns) == 0 {
+		return ""
+	}
+	if i := strings.Index(rdns[0], "="); i >= 0 {
+		return strings.ReplaceAll(rdns[0][i+1:], `\`, "")
+	}
+	return rdns[0]
+}
+
+// dnToDomain returns uppercase DNS name of the domain of the DN, e.g. 'TESTLAB.LOCAL'
+func dnToDomain(dn string) string {
+	var parts []string
+	for _, rdn := range splitDN(dn) {
+		if len(rdn) > 3 && strings.EqualFold(rdn[:3], "DC=") {
+			parts = append(parts, rdn[3:])
+		}
+	}
+	return strings.ToUpper(strings.Join(parts, "."))
+}
+
+func normaliseDN(dn string) string {
+	return strings.ToLower(strings.Join(splitDN(dn), ","))
+}
+
+// fileTimeToUnix converts Windows FILETIME (100ns intervals since 1601) to unix time,
+// 0 and never (max int64) are returned as 0 and -1 same as SharpHound
+func fileTimeToUnix(v int64) int64 {
+	switch {
+	case v == 0:
+		return 0
+	case v < 0 || v == 0x7FFFFFFFFFFFFFFF:
+		return -1
+	}
+	return v/10000000 - 11644473600
+}
+
+// generalizedTimeToUnix converts LDAP generalized time e.g. '20200101120000.0Z' to unix time
+func generalizedTimeToUnix(v string) int64 {
+	if len(v) < 14 {
+		return 0
+	}
+	t, err := time.Parse("20060102150405", v[:14])
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// gpLink is a link to GPO parsed from gPLink attribute
+type gpLink struct {
+	dn       string
+	enforced bool
+}
+
+// parseGPLink parses gPLink attribute e.g. '[LDAP://cn={GUID},cn=policies,cn=system,DC=testlab,DC=local;0]',
+// disabled links are skipped
+func parseGPLink(v string) []gpLink {
+	var links []gpLink
+	for _, part := range strings.Split(v, "[") {
+		part = strings.TrimSuffix(strings.TrimSpace(part), "]")
+		i := strings.LastIndex(part, ";")
+		if i < 0 {
+			continue
+		}
+		dn := part[:i]
+		if len(dn) > 7 && strings.EqualFold(dn[:7], "LDAP://") {
+			dn = dn[7:]
+		}
+		status, err := strconv.Atoi(part[i+1:])
+		if err != nil {
+			continue
+		}
+		// 1 = link disabled, 2 = enforced
+		if status&1 != 0 {
+			continue
+		}
+		links = append(links, gpLink{dn: dn, enforced: status&2 != 0})
+	}
+	return links
+}
+
+// well known high value groups, domain relative RIDs and builtin SIDs
+var (
+	highValueRIDs = map[string]bool{"512": true, "516": true, "518": true, "519": true}
+	highValueSIDs = map[string]bool{
+		"S-1-5-32-544": true, "S-1-5-32-548": true, "S-1-5-32-549": true,
+		"S-1-5-32-550": true, "S-1-5-32-551": true,
+	}
+)
+
+func isHighValueSID(sid string) bool {
+	if highValueSIDs[sid] {
+		return true
+	}
+	i := strings.LastIndex(sid, "-")
+	return strings.HasPrefix(sid, "S-1-5-21-") && i >= 0 && highValueRIDs[sid[i+1:]]
+}
+
+// directoryObject is an entry which is imported as a node
+type directoryObject struct {
+	entry  *directoryEntry
+	id     string
+	label  string
+	domain string
+	sid    string
+}
+
+// directoryConverter converts directory entries to Bloodhound data
+type directoryConverter struct {
+	objects []*directoryObject
+	// byDN is indexed by normalised DN
+	byDN map[string]*directoryObject
+	// bySID is indexed by SID without domain prefix
+	bySID map[string]*directoryObject
+	// hosts is indexed by uppercase DNS host name and sAMAccountName (without '$') of computers
+	hosts map[string]*directoryObject
+	// spns is indexed by uppercase SPN of users and computers
+	spns map[string]*directoryObject
+	// domainSIDs is indexed by domain name
+	domainSIDs map[string]string
+	// lapsGUIDs are schemaIDGUIDs of LAPS password attributes
+	lapsGUIDs map[string]bool
+	// memberOf contains DNs of members indexed by normalised DN of the group,
+	// used when exports only contain memberOf side of the membership
+	memberOf map[string][]string
+	// entries contains all entries indexed by normalised DN, used to follow ACE
+	// inheritance through containers which aren't converted
+	entries map[string]*directoryEntry
+	// sds caches parsed security descriptors of containers indexed by normalised DN
+	sds map[string]*securityDescriptor
+}
+
+// convertDirectory converts LDAP entries to the same structures as SharpHound json
+// data. entries which aren't users, computers, groups, OUs, GPOs or domains are
+// only used to resolve containers, trusts and schema. schema contains attribute
+// names indexed by schemaIDGUID when it's not part of the entries
+func convertDirectory(entries []*directoryEntry, schema map[string]string) *bloodHoundRawData {
+	c := &directoryConverter{
+		byDN:       make(map[string]*directoryObject),
+		bySID:      make(map[string]*directoryObject),
+		hosts:      make(map[string]*directoryObject),
+		spns:       make(map[string]*directoryObject),
+		domainSIDs: make(map[string]string),
+		lapsGUIDs:  make(map[string]bool),
+		memberOf:   make(map[string][]string),
+		entries:    make(map[string]*directoryEntry),
+		sds:        make(map[string]*securityDescriptor),
+	}
+	var trusts []*directoryEntry
+	for _, e := range entries {
+		switch {
+		case e.hasClass("trustedDomain"):
+			trusts = append(trusts, e)
+		case e.hasClass("attributeSchema"):
+			if guid, err := parseGUID(e.raw("schemaidguid")); err == nil {
+				c.addSchemaAttribute(guid, e.get("ldapdisplayname"))
+			}
+		}
+		c.entries[normaliseDN(e.dn)] = e
+		for _, g := range e.values("memberof") {
+			c.memberOf[normaliseDN(g)] = append(c.memberOf[normaliseDN(g)], e.dn)
+		}
+		c.index(e)
+	}
+	for guid, name := range schema {
+		c.addSchemaAttribute(guid, name)
+	}
+
+	data := &bloodHoundRawData{Meta: meta{Type: metaTypeAll, Count: len(c.objects)}}
+	for _, o := range c.objects {
+		switch o.label {
+		case "User":
+			data.Users = append(data.Users, c.user(o))
+		case "Computer":
+			data.Computers = append(data.Computers, c.computer(o))
+		case "Group":
+			data.Groups = append(data.Groups, c.group(o))
+		case "GPO":
+			data.Gpos = append(data.Gpos, c.gpo(o))
+		}
+	}
+	// containers are converted last as their content is resolved from other objects
+	children := c.children()
+	for _, o := range c.objects {
+		switch o.label {
+		case "OU":
+			data.OUs = append(data.OUs, c.ou(o, children[o]))
+		case "Domain":
+			data.Domains = append(data.Domains, c.domain(o, children[o], trusts))
+		}
+	}
+	return data
+}
+
+func (c *directoryConverter) index(e *directoryEntry) {
+	label := e.label()
+	if label == "" {
+		return
+	}
+	o := &directoryObject{entry: e, label: label, domain: dnToDomain(e.dn)}
+	if sid, err := parseSID(e.raw("objectsid")); err == nil {
+		o.sid = sid
+	}
+	switch label {
+	case "OU", "GPO":
+		guid, err := parseGUID(e.raw("objectguid"))
+		if err != nil {
+			return
+		}
+		o.id = guid
+	default:
+		if o.sid == "" {
+			return
+		}
+		o.id = normaliseID(o.sid, o.domain)
+		c.bySID[o.sid] = o
+	}
+	if label == "Domain" {
+		c.domainSIDs[o.domain] = o.sid
+	}
+	if label == "Computer" {
+		if h := e.get("dnshostname"); h != "" {
+			c.hosts[strings.ToUpper(h)] = o
+		}
+		c.hosts[strings.ToUpper(strings.TrimSuffix(e.get("samaccountname"), "$"))] = o
+	}
+	if label == "User" || label == "Computer" {
+		for _, spn := range e.values("serviceprincipalname") {
+			c.spns[strings.ToUpper(spn)] = o
+		}
+	}
+	c.objects = append(c.objects, o)
+	c.byDN[normaliseDN(e.dn)] = o
+}
+
+func (c *directoryConverter) addSchemaAttribute(guid, name string) {
+	for _, a := range lapsAttributes {
+		if strings.EqualFold(name, a) {
+			c.lapsGUIDs[guid] = true
+		}
+	}
+}
+
+// container returns nearest OU or domain which contains the object
+func (c *directoryConverter) container(dn string) *directoryObject {
+	for p := parentDN(dn); p != ""; p = parentDN(p) {
+		if o, ok := c.byDN[normaliseDN(p)]; ok && (o.label == "OU" || o.label == "Domain") {
+			return o
+		}
+	}
+	return nil
+}
+
+// children returns objects grouped by their container
+func (c *directoryConverter) children() map[*directoryObject][]*directoryObject {
+	children := make(map[*directoryObject][]*directoryObject)
+	for _, o := range c.objects {
+		if o.label == "Domain" {
+			continue
+		}
+		if p := c.container(o.entry.dn); p != nil {
+			children[p] = append(children[p], o)
+		}
+	}
+	return children
+}
+
+// resolveDN returns member for the DN, foreign security principals are resolved by SID
+func (c *directoryConverter) resolveDN(dn string) (member, bool) {
+	if o, ok := c.byDN[normaliseDN(dn)]; ok {
+		return member{MemberID: o.id, MemberType: o.label}, true
+	}
+	if cn := rdnValue(dn); strings.HasPrefix(cn, "S-1-") {
+		return c.resolveSID(cn, dnToDomain(dn)), true
+	}
+	return member{}, false
+}
+
+// resolveSID returns member for the SID, unknown SIDs are returned as Base
+func (c *directoryConverter) resolveSID(sid, domain string) member {
+	if o, ok := c.bySID[sid]; ok {
+		return member{MemberID: o.id, MemberType: o.label}
+	}
+	return member{MemberID: normaliseID(sid, domain), MemberType: "Base"}
+}
+
+// resolveHost returns computer for the host part of SPN
+func (c *directoryConverter) resolveHost(spn string) *directoryObject {
+	host := spn
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.IndexAny(host, ":/"); i >= 0 {
+		host = host[:i]
+	}
+	return c.hosts[strings.ToUpper(host)]
+}
+
+func (c *directoryConverter) primaryGroupSid(o *directoryObject) string {
+	rid := o.entry.get("primarygroupid")
+	domainSID := c.domainSIDs[o.domain]
+	if rid == "" || domainSID == "" {
+		return ""
+	}
+	return domainSID + "-" + rid
+}
+
+// aces returns ACEs of the object parsed from its nTSecurityDescriptor
+func (c *directoryConverter) aces(o *directoryObject) []ace {
+	e := o.entry
+	raw := e.raw("ntsecuritydescriptor")
+	if raw == nil {
+		return nil
+	}
+	sd, err := parseSecurityDescriptor(raw)
+	if err != nil {
+		log.WithField("dn", e.dn).WithError(err).Warn("unable to parse security descriptor")
+		return nil
+	}
+
+	var aces []ace
+	seen := make(map[ace]bool)
+	add := func(a ace) {
+		if !seen[a] {
+			seen[a] = true
+			aces = append(aces, a)
+		}
+	}
+
+	if sd.owner != "" && !isFilteredSID(sd.owner) {
+		p := c.resolveSID(sd.owner, o.domain)
+		add(ace{PrincipalSID: p.MemberID, PrincipalType: p.MemberType, RightName: "Owner"})
+	}
+
+	classes := e.values("objectclass")
+	hasLAPS := e.has("ms-mcs-admpwdexpirationtime") || e.has("mslaps-passwordexpirationtime")
+	for _, a := range sd.dacl {
+		if isFilteredSID(a.sid) || !a.appliesTo(classes) {
+			continue
+		}
+		p := c.resolveSID(a.sid, o.domain)
+		inherited := a.flags&aceFlagInherited != 0
+		var source string
+		if inherited {
+			source = c.aceSource(e.dn, a)
+		}
+		for _, r := range a.rights(o.label, hasLAPS, c.lapsGUIDs) {
+			add(ace{
+				PrincipalSID:  p.MemberID,
+				PrincipalType: p.MemberType,
+				RightName:     r.rightName,
+				AceType:       r.aceType,
+				IsInherited:   inherited,
+				InheritedFrom: source,
+			})
+		}
+	}
+	return aces
+}
+
+// aceSource returns objectid of OU or domain with explicit inheritable ACE the inherited
+// ACE originates from, ACEs must grant same rights to the same principal. parents are searched up
+// to the first one which doesn't inherit ACEs, empty string is returned if source isn't found or it isn't OU or domain
+func (c *directoryConverter) aceSource(dn string, a accessControlEntry) string {
+	mask := a.mappedMask()
+	for p := parentDN(dn); p != ""; p = parentDN(p) {
+		sd := c.containerSecurityDescriptor(p)
+		if sd == nil {
+			return ""
+		}
+		for _, pa := range sd.dacl {
+			if pa.flags&aceFlagInherited == 0 && pa.inheritable() && pa.aceType == a.aceType && pa.sid == a.sid &&
+				pa.objectType == a.objectType && pa.inheritedObjectType == a.inheritedObjectType && pa.mappedMask() == mask {
+				if o, ok := c.byDN[normaliseDN(p)]; ok && (o.label == "OU" || o.label == "Domain") {
+					return o.id
+				}
+				return ""
+			}
+		}
+		if sd.daclProtected() {
+			return ""
+		}
+	}
+	return ""
+}
+
+// containerSecurityDescriptor returns parsed nTSecurityDescriptor of the entry, nil is
+// returned if entry or its security descriptor is missing or invalid
+func (c *directoryConverter) containerSecurityDescriptor(dn string) *securityDescriptor {
+	key := normaliseDN(dn)
+	if sd, ok := c.sds[key]; ok {
+		return sd
+	}
+	var sd *securityDescriptor
+	if e, ok := c.entries[key]; ok {
+		if parsed, err := parseSecurityDescriptor(e.raw("ntsecuritydescriptor")); err == nil {
+			sd = parsed
+		}
+	}
+	c.sds[key] = sd
+	return sd
+}
+
+// gmsaAces returns ReadGMSAPassword ACEs of principals allowed to read gMSA password
+func (c *directoryConverter) gmsaAces(o *directoryObject) []ace {
+	raw := o.entry.raw("msds-groupmsamembership")
+	if raw == nil {
+		return nil
+	}
+	sd, err := parseSecurityDescriptor(raw)
+	if err != nil {
+		log.WithField("dn", o.entry.dn).WithError(err).Warn("unable to parse gMSA membership")
+		return nil
+	}
+	var aces []ace
+	for _, a := range sd.dacl {
+		p := c.resolveSID(a.sid, o.domain)
+		aces = append(aces, ace{PrincipalSID: p.MemberID, PrincipalType: p.MemberType, RightName: "ReadGMSAPassword"})
+	}
+	return aces
+}
+
+// baseProperties returns properties shared by all node types
+func (c *directoryConverter) baseProperties(o *directoryObject) map[string]interface{} {
+	e := o.entry
+	props := map[string]interface{}{
+		"objectid":          o.id,
+		"domain":            o.domain,
+		"distinguishedname": e.dn,
+		"highvalue":         isHighValueSID(o.sid),
+	}
+	if v := e.get("description"); v != "" {
+		props["description"] = v
+	}
+	if e.has("whencreated") {
+		props["whencreated"] = generalizedTimeToUnix(e.get("whencreated"))
+	}
+	return props
+}
+
+// principalProperties returns properties of users and computers
+func (c *directoryConverter) principalProperties(o *directoryObject) map[string]interface{} {
+	e := o.entry
+	props := c.baseProperties(o)
+	props["samaccountname"] = e.get("samaccountname")
+	props["admincount"] = e.get("admincount") == "1"
+	spns := e.values("serviceprincipalname")
+	if spns == nil {
+		spns = []string{}
+	}
+	props["serviceprincipalnames"] = spns
+	props["hasspn"] = len(spns) > 0
+	for _, attr := range []string{"pwdlastset", "lastlogon", "lastlogontimestamp"} {
+		if e.has(attr) {
+			props[attr] = fileTimeToUnix(e.int(attr))
+		}
+	}
+	if e.has("useraccountcontrol") {
+		props["useraccountcontrol"] = e.int("useraccountcontrol")
+	}
+	if delegates := e.values("msds-allowedtodelegateto"); len(delegates) > 0 {
+		props["allowedtodelegate"] = delegates
+	}
+	var history []string
+	for _, raw := range e.attrs["sidhistory"] {
+		if sid, err := parseSID(raw); err == nil {
+			history = append(history, sid)
+		}
+	}
+	props["sidhistory"] = history
+	return props
+}
+
+func (c *directoryConverter) sidHistory(o *directoryObject) []member {
+	var members []member
+	for _, raw := range o.entry.attrs["sidhistory"] {
+		if sid, err := parseSID(raw); err == nil {
+			members = append(members, c.resolveSID(sid, o.domain))
+		}
+	}
+	return members
+}
+
+// allowedToDelegate returns constrained delegation targets with SPNs delegated to them, SPN is
+// resolved to account it's registered on (service accounts can be users) or computer of its host
+func (c *directoryConverter) allowedToDelegate(o *directoryObject) []delegateTarget {
+	var targets []delegateTarget
+	index := make(map[string]int)
+	for _, spn := range o.entry.values("msds-allowedtodelegateto") {
+		t, ok := c.spns[strings.ToUpper(spn)]
+		if !ok {
+			t = c.resolveHost(spn)
+		}
+		if t == nil {
+			continue
+		}
+		i, ok := index[t.id]
+		if !ok {
+			i = len(targets)
+			index[t.id] = i
+			targets = append(targets, delegateTarget{ObjectIdentifier: t.id, ObjectType: t.label})
+		}
+		targets[i].SPNs = append(targets[i].SPNs, spn)
+	}
+	return targets
+}
+
+func (c *directoryConverter) user(o *directoryObject) user {
+	e := o.entry
+	props := c.principalProperties(o)
+	props["name"] = strings.ToUpper(e.get("samaccountname")) + "@" + o.domain
+	for attr, prop := range map[string]string{
+		"displayname":   "displayname",
+		"mail":          "email",
+		"title":         "title",
+		"homedirectory": "homedirectory",
+	} {
+		if v := e.get(attr); v != "" {
+			props[prop] = v
+		}
+	}
+
+	// SQL servers running as the user
+	var targets []spnTarget
+	for _, spn := range e.values("serviceprincipalname") {
+		if !strings.HasPrefix(strings.ToUpper(spn), "MSSQLSVC/") {
+			continue
+		}
+		t := c.resolveHost(spn)
+		if t == nil {
+			continue
+		}
+		port := 1433
+		if i := strings.LastIndex(spn, ":"); i >= 0 {
+			if p, err := strconv.Atoi(spn[i+1:]); err == nil {
+				port = p
+			}
+		}
+		targets = append(targets, spnTarget{ComputerSid: t.id, Port: port, Service: "SQLAdmin"})
+	}
+
+	return user{
+		ObjectIdentifier:  o.id,
+		Properties:        props,
+		AllowedToDelegate: c.allowedToDelegate(o),
+		SPNTargets:        targets,
+		PrimaryGroupSid:   c.primaryGroupSid(o),
+		HasSIDHistory:     c.sidHistory(o),
+		Aces:              append(c.aces(o), c.gmsaAces(o)...),
+	}
+}
+
+func (c *directoryConverter) computer(o *directoryObject) computer {
+	e := o.entry
+	props := c.principalProperties(o)
+	name := strings.ToUpper(e.get("dnshostname"))
+	if name == "" {
+		name = strings.ToUpper(strings.TrimSuffix(e.get("samaccountname"), "$")) + "." + o.domain
+	}
+	props["name"] = name
+	if v := e.get("operatingsystem"); v != "" {
+		props["operatingsystem"] = strings.TrimSpace(v + " " + e.get("operatingsystemservicepack"))
+	}
+	props["haslaps"] = e.has("ms-mcs-admpwdexpirationtime")
+
+	return computer{
+		ObjectIdentifier:  o.id,
+		Properties:        props,
+		AllowedToDelegate: c.allowedToDelegate(o),
+		PrimaryGroupSid:   c.primaryGroupSid(o),
+		Aces:              c.aces(o),
+	}
+}
+
+func (c *directoryConverter) group(o *directoryObject) group {
+	e := o.entry
+	props := c.baseProperties(o)
+	name := e.get("samaccountname")
+	if name == "" {
+		name = rdnValue(e.dn)
+	}
+	props["name"] = strings.ToUpper(name) + "@" + o.domain
+	props["admincount"] = e.get("admincount") == "1"
+
+	var members []member
+	seen := make(map[string]bool)
+	for _, dn := range append(e.values("member"), c.memberOf[normaliseDN(e.dn)]...) {
+		if m, ok := c.resolveDN(dn); ok && !seen[m.MemberID] {
+			seen[m.MemberID] = true
+			members = append(members, m)
+		}
+	}
+	return group{ObjectIdentifier: o.id, Properties: props, Members: members, Aces: c.aces(o)}
+}
+
+func (c *directoryConverter) gpo(o *directoryObject) gpo {
+	e := o.entry
+	props := c.baseProperties(o)
+	name := e.get("displayname")
+	if name == "" {
+		name = rdnValue(e.dn)
+	}
+	props["name"] = strings.ToUpper(name) + "@" + o.domain
+	props["gpcpath"] = strings.ToUpper(e.get("gpcfilesyspath"))
+	return gpo{ObjectIdentifier: o.id, Properties: props, Aces: c.aces(o)}
+}
+
+func (c *directoryConverter) links(o *directoryObject) []link {
+	var links []link
+	for _, l := range parseGPLink(o.entry.get("gplink")) {
+		if g, ok := c.byDN[normaliseDN(l.dn)]; ok {
+			links = append(links, link{GUID: g.id, IsEnforced: l.enforced})
+		}
+	}
+	return links
+}
+
+// contents returns ids of users, computers and OUs in the container
+func contents(children []*directoryObject) (users, computers, ous []string) {
+	users, computers, ous = []string{}, []string{}, []string{}
+	for _, ch := range children {
+		switch ch.label {
+		case "User":
+			users = append(users, ch.id)
+		case "Computer":
+			computers = append(computers, ch.id)
+		case "OU":
+			ous = append(ous, ch.id)
+		}
+	}
+	sort.Strings(users)
+	sort.Strings(computers)
+	sort.Strings(ous)
+	return users, computers, ous
+}
+
+func (c *directoryConverter) ou(o *directoryObject, children []*directoryObject) ou {
+	e := o.entry
+	props := c.baseProperties(o)
+	props["name"] = strings.ToUpper(rdnValue(e.dn)) + "@" + o.domain
+	props["blocksinheritance"] = e.get("gpoptions") == "1"
+
+	users, computers, ous := contents(children)
+	return ou{
+		ObjectIdentifier: o.id,
+		Properties:       props,
+		Links:            c.links(o),
+		ACLProtected:     c.aclProtected(o),
+		Users:            users,
+		Computers:        computers,
+		ChildOus:         ous,
+		Aces:             c.aces(o),
+	}
+}
+
+// aclProtected returns whether inheritance of ACEs is disabled for the object
+func (c *directoryConverter) aclProtected(o *directoryObject) bool {
+	sd, err := parseSecurityDescriptor(o.entry.raw("ntsecuritydescriptor"))
+	return err == nil && sd.daclProtected()
+}
+
+// msDS-Behavior-Version to functional level
+var functionalLevels = map[string]string{
+	"0": "2000 Mixed/Native", "1": "2003 Interim", "2": "2003", "3": "2008",
+	"4": "2008 R2", "5": "2012", "6": "2012 R2", "7": "2016",
+}
+
+func (c *directoryConverter) domain(o *directoryObject, children []*directoryObject, trusts []*directoryEntry) domain {
+	e := o.entry
+	props := c.baseProperties(o)
+	props["name"] = o.domain
+	if fl, ok := functionalLevels[e.get("msds-behavior-version")]; ok {
+		props["functionallevel"] = fl
+	}
+
+	users, computers, ous := contents(children)
+	d := domain{
+		ObjectIdentifier: o.id,
+		Properties:       props,
+		Links:            c.links(o),
+		Users:            users,
+		Computers:        computers,
+		ChildOus:         ous,
+		Aces:             c.aces(o),
+	}
+
+	for _, t := range trusts {
+		if dnToDomain(t.dn) != o.domain {
+			continue
+		}
+		sid, err := parseSID(t.raw("securityidentifier"))
+		if err != nil {
+			continue
+		}
+		attributes := t.int("trustattributes")
+		d.Trusts = append(d.Trusts, trust{
+			TargetDomainSid:     sid,
+			TargetDomainName:    strings.ToUpper(t.get("trustpartner")),
+			IsTransitive:        attributes&trustAttributeNonTransitive == 0,
+			TrustDirection:      int(t.int("trustdirection")),
+			TrustType:           trustTypeFromAttributes(t.int("trusttype"), attributes),
+			SidFilteringEnabled: attributes&trustAttributeQuarantinedDomain != 0,
+			TrustAttributes:     attributes,
+		})
+	}
+	return d
+}
+
+// trustAttributes flags
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-adts/e9a2d23c-c31e-4a6f-88a0-6646fdb51a3c
+const (
+	trustAttributeNonTransitive     = 0x1
+	trustAttributeQuarantinedDomain = 0x4
+	trustAttributeForestTransitive  = 0x8
+	trustAttributeWithinForest      = 0x20
+	trustAttributeTreatAsExternal   = 0x40
+)
+
+// trustTypeFromAttributes converts AD trustType and trustAttributes to
+// SharpHound trust type (ParentChild = 0, CrossLink = 1, Forest = 2, External = 3, Unknown = 4)
+func trustTypeFromAttributes(trustType, attributes int64) int {
+	switch {
+	case attributes&trustAttributeWithinForest != 0:
+		return 0
+	case attributes&trustAttributeForestTransitive != 0:
+		return 2
+	case trustType == 1 || trustType == 2:
+		// downlevel and uplevel trusts outside of the forest
+		return 3
+	}
+	return 4
+}
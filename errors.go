@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// exit codes returned by the app
+const (
+	exitCodeError         = 1
+	exitCodeParseError    = 2
+	exitCodeConnectivity  = 3
+	exitCodePartialUpload = 4
+)
+
+// importError attaches exit code to the error returned by the app
+type importError struct {
+	code int
+	err  error
+}
+
+func (e *importError) Error() string {
+	return e.err.Error()
+}
+
+func (e *importError) Unwrap() error {
+	return e.err
+}
+
+func parseError(err error) error {
+	return &importError{code: exitCodeParseError, err: err}
+}
+
+func connectivityError(err error) error {
+	return &importError{code: exitCodeConnectivity, err: err}
+}
+
+func partialUploadError(err error) error {
+	return &importError{code: exitCodePartialUpload, err: err}
+}
+
+// isNeo4jConnectivityError reports whether err is connectivity error of neo4j driver, managed
+// transactions return connectivity error of the last attempt wrapped in TransactionExecutionLimit
+func isNeo4jConnectivityError(err error) bool {
+	var ce *neo4j.ConnectivityError
+	if errors.As(err, &ce) {
+		return true
+	}
+	var tel *neo4j.TransactionExecutionLimit
+	if errors.As(err, &tel) && len(tel.Errors) > 0 {
+		return errors.As(tel.Errors[len(tel.Errors)-1], &ce)
+	}
+	return false
+}
+
+// uploadError classifies error returned by neo4j driver
+func uploadError(err error) error {
+	if isNeo4jConnectivityError(err) {
+		return connectivityError(err)
+	}
+	return partialUploadError(err)
+}
+
+// exitCode returns exit code for the error, if multiple errors are
+// returned by the app the most severe is used. connectivity failures are
+// reported first as they usually cause every other failure
+func exitCode(errs ...error) int {
+	code := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		c := exitCodeError
+		var ie *importError
+		if errors.As(err, &ie) {
+			c = ie.code
+		}
+		switch {
+		case code == 0,
+			c == exitCodeConnectivity,
+			c == exitCodeParseError && code != exitCodeConnectivity:
+			code = c
+		}
+	}
+	return code
+}
+
+// errorGroup is similar to errgroup.Group but it collects errors of
+// all the goroutines instead of returning only the first one.
+// if failFast is set, first error cancels the context returned by newErrorGroup
+type errorGroup struct {
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	errs     []error
+	failFast bool
+	cancel   context.CancelFunc
+}
+
+func newErrorGroup(ctx context.Context, failFast bool) (*errorGroup, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &errorGroup{failFast: failFast, cancel: cancel}, ctx
+}
+
+// Go runs f in new goroutine
+func (g *errorGroup) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := f(); err != nil {
+			g.mu.Lock()
+			g.errs = append(g.errs, err)
+			g.mu.Unlock()
+			if g.failFast {
+				g.cancel()
+			}
+		}
+	}()
+}
+
+// stop cancels the group context
+func (g *errorGroup) stop() {
+	g.cancel()
+}
+
+// Wait blocks until all goroutines have returned and returns all their errors
+func (g *errorGroup) Wait() []error {
+	g.wg.Wait()
+	g.cancel()
+	return g.errs
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+func Test_exitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		errs []error
+		want int
+	}{
+		{"no errors", nil, 0},
+		{"generic error", []error{errors.New("err")}, exitCodeError},
+		{"wrapped parse error", []error{fmt.Errorf("file: %w", parseError(errors.New("bad json")))}, exitCodeParseError},
+		{"parse and partial upload", []error{partialUploadError(errors.New("a")), parseError(errors.New("b"))}, exitCodeParseError},
+		{"connectivity wins", []error{parseError(errors.New("a")), connectivityError(errors.New("b")), partialUploadError(errors.New("c"))}, exitCodeConnectivity},
+		{"retried connectivity error", []error{uploadError(&neo4j.TransactionExecutionLimit{Errors: []error{errors.New("a"), &neo4j.ConnectivityError{}}})}, exitCodeConnectivity},
+		{"retried transient error", []error{uploadError(&neo4j.TransactionExecutionLimit{Errors: []error{&neo4j.ConnectivityError{}, errors.New("b")}})}, exitCodePartialUpload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.errs...); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_errorGroup(t *testing.T) {
+	for _, failFast := range []bool{true, false} {
+		g, ctx := newErrorGroup(context.Background(), failFast)
+		g.Go(func() error { return errors.New("failed") })
+		g.Go(func() error { return nil })
+		// wait for first error before checking context
+		g.wg.Wait()
+		if cancelled := ctx.Err() != nil; cancelled != failFast {
+			t.Errorf("failFast=%t: context cancelled = %t", failFast, cancelled)
+		}
+		if errs := g.Wait(); len(errs) != 1 {
+			t.Errorf("failFast=%t: Wait() returned %d errors, want 1", failFast, len(errs))
+		}
+	}
+}
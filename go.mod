@@ -5,10 +5,13 @@ go 1.16
 require (
 	github.com/Binject/debug v0.0.0-20210225042342-c9b8b45728d2 // indirect
 	github.com/Binject/go-donut v0.0.0-20201215224200-d947cf4d090d
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.3.0
 	github.com/google/go-cmp v0.5.4
 	github.com/google/uuid v1.2.0 // indirect
 	github.com/neo4j/neo4j-go-driver/v4 v4.2.1-0.20201214080657-9dda4e695468
 	github.com/sirupsen/logrus v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/sys v0.0.0-20210305230114-8fe3ee5dd75b
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/sirupsen/logrus"
+)
+
+// gpoGrant is local group membership granted to computers by GPOs linked to container
+type gpoGrant struct {
+	right    string
+	member   member
+	enforced bool
+}
+
+// gpoContainer is OU or domain in GPO inheritance tree
+type gpoContainer struct {
+	children          []string
+	computers         []string
+	blocksInheritance bool
+	grants            []gpoGrant
+}
+
+// gpoTree collects OUs and domains of all inputs so local group memberships granted
+// by GPOs can be applied to computers in child OUs once every input is processed.
+// SharpHound only sets memberships on computers directly in the container GPO is linked to
+type gpoTree struct {
+	mu         sync.Mutex
+	containers map[string]*gpoContainer
+}
+
+func newGPOTree() *gpoTree {
+	return &gpoTree{containers: make(map[string]*gpoContainer)}
+}
+
+// containerGrants returns grants of container, SharpHound doesn't record which linked GPO
+// granted the membership so grants are treated as enforced if any link is enforced
+func containerGrants(links []link, localAdmins, rdp, dcom, psRemote []member) []gpoGrant {
+	enforced := false
+	for _, l := range links {
+		enforced = enforced || l.IsEnforced
+	}
+	var grants []gpoGrant
+	for right, members := range map[string][]member{
+		"AdminTo":     localAdmins,
+		"CanRDP":      rdp,
+		"ExecuteDCOM": dcom,
+		"CanPSRemote": psRemote,
+	} {
+		for _, m := range members {
+			grants = append(grants, gpoGrant{right: right, member: m, enforced: enforced})
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].right != grants[j].right {
+			return grants[i].right < grants[j].right
+		}
+		return grants[i].member.MemberID < grants[j].member.MemberID
+	})
+	return grants
+}
+
+// add adds OUs and domains of given types to the tree, tree can be nil if inheritance isn't resolved
+func (t *gpoTree) add(data *bloodHoundRawData, types []string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, metaType := range types {
+		switch metaType {
+		case "ous":
+			for _, o := range data.OUs {
+				v, _ := coerceProperty(o.Properties["blocksinheritance"], propertyBool)
+				blocks, _ := v.(bool)
+				t.containers[o.ObjectIdentifier] = &gpoContainer{
+					children:          o.ChildOus,
+					computers:         o.Computers,
+					blocksInheritance: blocks,
+					grants:            containerGrants(o.Links, o.LocalAdmins, o.RemoteDesktopUsers, o.DcomUsers, o.PSRemoteUsers),
+				}
+			}
+		case "domains":
+			for _, o := range data.Domains {
+				t.containers[o.ObjectIdentifier] = &gpoContainer{
+					children:  o.ChildOus,
+					computers: o.Computers,
+					grants:    containerGrants(o.Links, o.LocalAdmins, o.RemoteDesktopUsers, o.DcomUsers, o.PSRemoteUsers),
+				}
+			}
+		}
+	}
+}
+
+// cyphers returns edges from principals granted by GPOs linked to parent containers
+// to computers in child OUs. GPOs aren't inherited by OUs which block inheritance
+// unless their link is enforced
+func (t *gpoTree) cyphers() map[string]*cypher {
+	cyphers := make(map[string]*cypher)
+	if t == nil {
+		return cyphers
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	isChild := make(map[string]bool)
+	for _, c := range t.containers {
+		for _, id := range c.children {
+			isChild[id] = true
+		}
+	}
+	var roots []string
+	for id := range t.containers {
+		if !isChild[id] {
+			roots = append(roots, id)
+		}
+	}
+	sort.Strings(roots)
+
+	visited := make(map[string]bool)
+	seen := make(map[[3]string]bool)
+	for _, id := range roots {
+		t.resolve(cyphers, id, nil, visited, seen)
+	}
+	return cyphers
+}
+
+func (t *gpoTree) resolve(cyphers map[string]*cypher, id string, inherited []gpoGrant, visited map[string]bool, seen map[[3]string]bool) {
+	c, ok := t.containers[id]
+	if !ok || visited[id] {
+		return
+	}
+	visited[id] = true
+
+	if c.blocksInheritance {
+		var enforced []gpoGrant
+		for _, g := range inherited {
+			if g.enforced {
+				enforced = append(enforced, g)
+			}
+		}
+		inherited = enforced
+	}
+
+	for _, g := range inherited {
+		for _, computer := range c.computers {
+			key := [3]string{g.right, g.member.MemberID, computer}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			st := buildRelStatement(g.member.MemberType, "Computer", g.right, "{isacl:false, fromgpo: true}")
+			ht := hash(st)
+			if _, ok := cyphers[ht]; !ok {
+				cyphers[ht] = new(cypher)
+				cyphers[ht].statement = st
+			}
+			cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"source": g.member.MemberID, "target": computer})
+		}
+	}
+
+	next := append(inherited[:len(inherited):len(inherited)], c.grants...)
+	for _, child := range c.children {
+		t.resolve(cyphers, child, next, visited, seen)
+	}
+}
+
+// gpoInheritanceBatchSize is number of inherited edges uploaded in a single transaction
+const gpoInheritanceBatchSize = 1000
+
+// batches splits rows of cyphers into batches of at most size rows, statements are in stable order
+func batches(cyphers map[string]*cypher, size int) []map[string]*cypher {
+	hashes := make([]string, 0, len(cyphers))
+	for h := range cyphers {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	var out []map[string]*cypher
+	current, rows := make(map[string]*cypher), 0
+	for _, h := range hashes {
+		list := cyphers[h].list
+		for len(list) > 0 {
+			n := size - rows
+			if n > len(list) {
+				n = len(list)
+			}
+			current[h] = &cypher{statement: cyphers[h].statement, list: list[:n]}
+			list, rows = list[n:], rows+n
+			if rows == size {
+				out = append(out, current)
+				current, rows = make(map[string]*cypher), 0
+			}
+		}
+	}
+	if rows > 0 {
+		out = append(out, current)
+	}
+	return out
+}
+
+// uploadGPOInheritance uploads edges resolved from GPO inheritance in batches,
+// each batch is uploaded in its own transaction
+func uploadGPOInheritance(ctx context.Context, driver neo4j.Driver, tree *gpoTree) error {
+	chunks := batches(tree.cyphers(), gpoInheritanceBatchSize)
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode: neo4j.AccessModeWrite,
+	})
+	defer session.Close()
+
+	rows := 0
+	for i, cyphers := range chunks {
+		if err := uploadBatch(ctx, session, &batch{file: "gpo inheritance", metaType: "ous", index: i, cyphers: cyphers}, batchTimeout); err != nil {
+			return uploadError(err)
+		}
+		for _, c := range cyphers {
+			rows += len(c.list)
+		}
+	}
+	log.WithFields(logrus.Fields{"rows": rows, "batches": len(chunks)}).Info("uploaded local group memberships inherited from GPOs")
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_gpoTree_cyphers(t *testing.T) {
+	data := &bloodHoundRawData{
+		Domains: []domain{{
+			ObjectIdentifier: "S-1-5-21-1",
+			ChildOus:         []string{"OU-SERVERS"},
+			Computers:        []string{"S-1-5-21-1-1000"},
+			Links:            []link{{GUID: "GPO-DEFAULT"}},
+			LocalAdmins:      []member{{MemberID: "S-1-5-21-1-512", MemberType: "Group"}},
+		}},
+		OUs: []ou{
+			{
+				ObjectIdentifier:   "OU-SERVERS",
+				ChildOus:           []string{"OU-WEB", "OU-DB"},
+				Computers:          []string{"S-1-5-21-1-1001"},
+				Links:              []link{{GUID: "GPO-RDP", IsEnforced: true}},
+				RemoteDesktopUsers: []member{{MemberID: "S-1-5-21-1-1105", MemberType: "User"}},
+			},
+			{
+				ObjectIdentifier: "OU-WEB",
+				Computers:        []string{"S-1-5-21-1-1002"},
+			},
+			{
+				ObjectIdentifier: "OU-DB",
+				Properties:       map[string]interface{}{"blocksinheritance": true},
+				Computers:        []string{"S-1-5-21-1-1003"},
+			},
+		},
+	}
+	adminTo := buildRelStatement("Group", "Computer", "AdminTo", "{isacl:false, fromgpo: true}")
+	canRDP := buildRelStatement("User", "Computer", "CanRDP", "{isacl:false, fromgpo: true}")
+
+	tests := []struct {
+		name  string
+		types []string
+		want  map[string]*cypher
+	}{
+		{
+			name:  "nested OUs",
+			types: []string{"domains", "ous"},
+			want: map[string]*cypher{
+				hash(adminTo): {
+					statement: adminTo,
+					list: []map[string]interface{}{
+						{"source": "S-1-5-21-1-512", "target": "S-1-5-21-1-1001"},
+						{"source": "S-1-5-21-1-512", "target": "S-1-5-21-1-1002"},
+					},
+				},
+				hash(canRDP): {
+					statement: canRDP,
+					list: []map[string]interface{}{
+						{"source": "S-1-5-21-1-1105", "target": "S-1-5-21-1-1002"},
+						{"source": "S-1-5-21-1-1105", "target": "S-1-5-21-1-1003"},
+					},
+				},
+			},
+		},
+		{
+			name:  "without domains",
+			types: []string{"ous"},
+			want: map[string]*cypher{
+				hash(canRDP): {
+					statement: canRDP,
+					list: []map[string]interface{}{
+						{"source": "S-1-5-21-1-1105", "target": "S-1-5-21-1-1002"},
+						{"source": "S-1-5-21-1-1105", "target": "S-1-5-21-1-1003"},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newGPOTree()
+			tree.add(data, tt.types)
+			got := tree.cyphers()
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(cypher{})); diff != "" {
+				t.Errorf("cyphers() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_batches(t *testing.T) {
+	rows := func(n int) []map[string]interface{} {
+		list := make([]map[string]interface{}, n)
+		for i := range list {
+			list[i] = map[string]interface{}{"i": i}
+		}
+		return list
+	}
+	a, b := rows(5), rows(2)
+	cyphers := map[string]*cypher{"a": {statement: "A", list: a}, "b": {statement: "B", list: b}}
+
+	want := []map[string]*cypher{
+		{"a": {statement: "A", list: a[:3]}},
+		{"a": {statement: "A", list: a[3:]}, "b": {statement: "B", list: b[:1]}},
+		{"b": {statement: "B", list: b[1:]}},
+	}
+	if diff := cmp.Diff(want, batches(cyphers, 3), cmp.AllowUnexported(cypher{})); diff != "" {
+		t.Errorf("batches() mismatch (-want got):\n%s", diff)
+	}
+	if got := batches(map[string]*cypher{"a": {statement: "A"}}, 3); len(got) != 0 {
+		t.Errorf("batches() of empty list = %v", got)
+	}
+}
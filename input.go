@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stdinInput is the input name used to read data from stdin
+const stdinInput = "-"
+
+// inputSource opens inputs, input can be a local file, stdin ('-'),
+// http(s) url or s3://bucket/key url of S3 compatible object storage
+type inputSource struct {
+	s3     *s3Client
+	client *http.Client
+}
+
+// isRemoteInput reports whether input is url
+func isRemoteInput(name string) bool {
+	for _, prefix := range []string{"http://", "https://", "s3://"} {
+		if strings.HasPrefix(strings.ToLower(name), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// inputBase returns last element of the input name, query string
+// and fragment of urls are ignored
+func inputBase(name string) string {
+	if isRemoteInput(name) {
+		if u, err := url.Parse(name); err == nil {
+			return path.Base(u.Path)
+		}
+		return path.Base(name)
+	}
+	return filepath.Base(name)
+}
+
+// inputExt returns lower case extension of the input name
+func inputExt(name string) string {
+	return strings.ToLower(path.Ext(inputBase(name)))
+}
+
+// isLocalFile reports whether input is file on local disk
+func isLocalFile(name string) bool {
+	return name != stdinInput && !isRemoteInput(name)
+}
+
+// open returns reader of the input, remote inputs are streamed
+// rather than downloaded first
+func (src *inputSource) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case name == stdinInput:
+		return ioutil.NopCloser(os.Stdin), nil
+	case strings.HasPrefix(lower, "s3://"):
+		if src.s3 == nil {
+			return nil, fmt.Errorf("s3 client is not configured")
+		}
+		bucket, key, err := parseS3URL(name)
+		if err != nil {
+			return nil, err
+		}
+		return src.s3.get(ctx, bucket, key)
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		client := src.client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unable to download %s: %s", name, resp.Status)
+		}
+		return resp.Body, nil
+	default:
+		return os.Open(filepath.Clean(name))
+	}
+}
+
+// inputOptions controls how input files are discovered in target directories
+type inputOptions struct {
+	recursive bool
+	// include and exclude are glob patterns, pattern without '/' is matched against
+	// file name otherwise its matched against path relative to target directory
+	include []string
+	exclude []string
+}
+
+// findInputFiles returns sorted list of unique files found in dirs and
+// explicitly listed files. explicit files are always included.
+// dirs can be local folders or s3://bucket/prefix urls
+func findInputFiles(ctx context.Context, src *inputSource, dirs, files []string, opts inputOptions) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	add := func(f string) {
+		if isLocalFile(f) {
+			f = filepath.Clean(f)
+		}
+		if !seen[f] {
+			seen[f] = true
+			result = append(result, f)
+		}
+	}
+
+	for _, f := range files {
+		add(f)
+	}
+
+	for _, dir := range dirs {
+		if strings.HasPrefix(strings.ToLower(dir), "s3://") {
+			if err := src.findS3Files(ctx, dir, opts, add); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if isRemoteInput(dir) {
+			return nil, fmt.Errorf("listing %s is not supported, only s3 urls can be used as target directory", dir)
+		}
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != dir && !opts.recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			ok, err := opts.match(rel)
+			if err != nil {
+				return err
+			}
+			if ok {
+				add(p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+func (src *inputSource) findS3Files(ctx context.Context, dir string, opts inputOptions, add func(string)) error {
+	if src.s3 == nil {
+		return fmt.Errorf("s3 client is not configured")
+	}
+	bucket, prefix, err := parseS3URL(dir)
+	if err != nil {
+		return err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	keys, err := src.s3.list(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, prefix)
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			continue
+		}
+		if !opts.recursive && strings.Contains(rel, "/") {
+			continue
+		}
+		ok, err := opts.match(filepath.FromSlash(rel))
+		if err != nil {
+			return err
+		}
+		if ok {
+			add("s3://" + bucket + "/" + key)
+		}
+	}
+	return nil
+}
+
+// match reports whether file at relative path rel should be imported
+func (o inputOptions) match(rel string) (bool, error) {
+	included, err := matchAny(o.include, rel)
+	if err != nil || !included {
+		return false, err
+	}
+	excluded, err := matchAny(o.exclude, rel)
+	return !excluded, err
+}
+
+func matchAny(patterns []string, rel string) (bool, error) {
+	// patterns always use '/' as separator so they work on every platform
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		name := rel
+		if !strings.Contains(p, "/") {
+			name = path.Base(rel)
+		}
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q %w", p, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkpointDir returns directory where checkpoint file is stored, it's the
+// first local target directory or directory of the first local file,
+// if all inputs are remote current directory is used
+func checkpointDir(dirs, files []string) string {
+	for _, d := range dirs {
+		if !isRemoteInput(d) {
+			return d
+		}
+	}
+	for _, f := range files {
+		if isLocalFile(f) {
+			return filepath.Dir(f)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return wd
+}
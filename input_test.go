@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_findInputFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, f := range []string{
+		"a/20210101_users.json",
+		"a/20210101_computers.json",
+		"a/old/20200101_users.json",
+		"a/notes.txt",
+		"b/2021/01/groups.json",
+		checkpointFileName,
+	} {
+		p := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j := func(f string) string { return filepath.Join(root, filepath.FromSlash(f)) }
+
+	tests := []struct {
+		name  string
+		dirs  []string
+		files []string
+		opts  inputOptions
+		want  []string
+	}{
+		{
+			name: "single directory",
+			dirs: []string{j("a")},
+			opts: inputOptions{include: []string{"*.json"}},
+			want: []string{j("a/20210101_computers.json"), j("a/20210101_users.json")},
+		},
+		{
+			name: "recursive with exclude",
+			dirs: []string{root},
+			opts: inputOptions{recursive: true, include: []string{"*.json"}, exclude: []string{"a/old/*"}},
+			want: []string{j("a/20210101_computers.json"), j("a/20210101_users.json"), j("b/2021/01/groups.json")},
+		},
+		{
+			name:  "multiple directories and explicit files",
+			dirs:  []string{j("a"), j("a")},
+			files: []string{j("a/notes.txt"), j("a/20210101_users.json")},
+			opts:  inputOptions{include: []string{"*_users.json"}},
+			want:  []string{j("a/20210101_users.json"), j("a/notes.txt")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findInputFiles(context.Background(), &inputSource{}, tt.dirs, tt.files, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("findInputFiles() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_inputExt(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: filepath.Join("data", "snapshot.DAT"), want: ".dat"},
+		{name: "https://host/bh/snapshot.dat?X-Amz-Credential=a%2Fb&X-Amz-Signature=c.json", want: ".dat"},
+		{name: "s3://bucket/2021/users.json", want: ".json"},
+		{name: "https://host/dump.ldif#part.csv", want: ".ldif"},
+		{name: "https://host/export?format=csv", want: ""},
+		{name: stdinInput, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inputExt(tt.name); got != tt.want {
+				t.Errorf("inputExt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseInput_errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/invalid.json":
+			w.Write([]byte(`{"users": [`))
+		case "/truncated.json":
+			// connection is closed before promised content is sent
+			w.Header().Set("Content-Length", "1000")
+			w.Write([]byte(`{"users": [`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		file string
+		want int
+	}{
+		{file: "/missing.json", want: exitCodeConnectivity},
+		{file: "/truncated.json", want: exitCodeConnectivity},
+		{file: "/invalid.json", want: exitCodeParseError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			_, _, err := parseInput(context.Background(), &inputSource{}, srv.URL+tt.file)
+			if got := exitCode(err); got != tt.want {
+				t.Errorf("parseInput() error = %v, exit code = %d, want %d", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_inputReader_withReaderAt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("snapshot"))
+	}))
+	defer srv.Close()
+	file := filepath.Join(t.TempDir(), "snapshot.dat")
+	if err := os.WriteFile(file, []byte("snapshot"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		readerAt bool
+	}{
+		{name: "local file", input: file, readerAt: true},
+		{name: "url", input: srv.URL + "/snapshot.dat", readerAt: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := (&inputSource{}).open(context.Background(), tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer input.Close()
+			ir := &inputReader{r: input}
+			ra, ok := ir.withReaderAt().(io.ReaderAt)
+			if ok != tt.readerAt {
+				t.Fatalf("withReaderAt() implements io.ReaderAt = %t, want %t", ok, tt.readerAt)
+			}
+			if !ok {
+				return
+			}
+			b := make([]byte, 4)
+			if _, err := ra.ReadAt(b, 4); err != nil || string(b) != "shot" {
+				t.Errorf("ReadAt() = %q, %v", b, err)
+			}
+			input.Close()
+			if _, err := ra.ReadAt(b, 0); err == nil || ir.err == nil {
+				t.Errorf("ReadAt() of closed file error = %v, recorded %v", err, ir.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseLDIF reads LDIF export of the directory e.g. ldapsearch output and
+// converts it to Bloodhound data, returned hash is sha256 of the whole input
+func parseLDIF(r io.Reader) (*bloodHoundRawData, string, error) {
+	h := sha256.New()
+	entries, err := readLDIF(io.TeeReader(r, h))
+	if err != nil {
+		return nil, "", err
+	}
+	log.Infof("read %d LDIF entries", len(entries))
+	return convertDirectory(entries, nil), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readLDIF reads LDIF content records (RFC 2849). records without dn e.g.
+// ldapsearch result summary are skipped, base64 ('::') values are decoded
+// so binary attributes are returned as raw bytes
+func readLDIF(r io.Reader) ([]*directoryEntry, error) {
+	var (
+		entries   []*directoryEntry
+		lines     []string
+		start     int
+		lineNo    int
+		inComment bool
+	)
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		e, err := parseLDIFRecord(lines)
+		lines = lines[:0]
+		if err != nil {
+			return fmt.Errorf("LDIF record at line %d %w", start, err)
+		}
+		if e != nil {
+			entries = append(entries, e)
+		}
+		return nil
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		lineNo++
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			inComment = false
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		case line[0] == ' ':
+			// continuation of previous line
+			if inComment {
+				continue
+			}
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("unexpected LDIF continuation at line %d", lineNo)
+			}
+			lines[len(lines)-1] += line[1:]
+		case line[0] == '#':
+			inComment = true
+		default:
+			inComment = false
+			if len(lines) == 0 {
+				start = lineNo
+			}
+			lines = append(lines, line)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseLDIFRecord parses unfolded lines of a record, nil is returned for records without dn
+func parseLDIFRecord(lines []string) (*directoryEntry, error) {
+	var e *directoryEntry
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		// attribute options e.g. 'objectSid;binary' or 'member;range=0-1499' are ignored
+		name := line[:i]
+		if j := strings.Index(name, ";"); j >= 0 {
+			name = name[:j]
+		}
+		value := line[i+1:]
+
+		var raw []byte
+		switch {
+		case strings.HasPrefix(value, ":"):
+			b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value of %s %w", name, err)
+			}
+			raw = b
+		case strings.HasPrefix(value, "<"):
+			// values referenced by URL aren't supported
+			continue
+		default:
+			raw = []byte(strings.TrimLeft(value, " "))
+		}
+
+		switch strings.ToLower(name) {
+		case "version", "changetype":
+		case "dn":
+			e = newDirectoryEntry(string(raw))
+		default:
+			if e == nil {
+				// ldapsearch trailer e.g. 'search: 2' and 'result: 0 Success'
+				return nil, nil
+			}
+			e.add(name, raw)
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_readLDIF(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "continuation, base64 and options",
+			input: "version: 1\n\n# comment\n  continued comment\ndn: CN=Test,DC=testlab,\n DC=local\r\n" +
+				"description:: dGVzdA==\nmember;range=0-1: CN=A\nmember;range=0-1: CN=B\n\n" +
+				"search: 2\nresult: 0 Success\n",
+			want: map[string]map[string][]string{
+				"CN=Test,DC=testlab,DC=local": {"description": {"test"}, "member": {"CN=A", "CN=B"}},
+			},
+		},
+		{
+			name:  "no trailing new line",
+			input: "dn: DC=testlab,DC=local\nobjectClass: domainDNS",
+			want: map[string]map[string][]string{
+				"DC=testlab,DC=local": {"objectclass": {"domainDNS"}},
+			},
+		},
+		{name: "invalid base64", input: "dn: DC=testlab,DC=local\nobjectSid:: ???\n", wantErr: true},
+		{name: "unexpected continuation", input: " DC=local\n", wantErr: true},
+		{name: "missing separator", input: "dn: DC=testlab,DC=local\ninvalid\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := readLDIF(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readLDIF() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			var got map[string]map[string][]string
+			for _, e := range entries {
+				if got == nil {
+					got = make(map[string]map[string][]string)
+				}
+				got[e.dn] = make(map[string][]string)
+				for name := range e.attrs {
+					got[e.dn][name] = e.values(name)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("readLDIF() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_parseLDIF(t *testing.T) {
+	f, err := os.Open("test_data/testlab.ldif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, hash, err := parseLDIF(f)
+	if err != nil {
+		t.Fatalf("parseLDIF() error = %v", err)
+	}
+	if hash == "" {
+		t.Error("parseLDIF() returned empty hash")
+	}
+
+	domainSID := "S-1-5-21-883232822-274137685-4173207997"
+	if diff := cmp.Diff([]string{"domains", "ous", "gpos", "groups", "users", "computers"}, data.contentTypes()); diff != "" {
+		t.Errorf("contentTypes() mismatch (-want got):\n%s", diff)
+	}
+
+	d := data.Domains[0]
+	wantTrusts := []trust{{
+		TargetDomainSid:     "S-1-5-21-1-2-3",
+		TargetDomainName:    "EXTERNAL.LOCAL",
+		IsTransitive:        true,
+		TrustDirection:      3,
+		TrustType:           3,
+		SidFilteringEnabled: true,
+		TrustAttributes:     4,
+	}}
+	if diff := cmp.Diff(wantTrusts, d.Trusts); diff != "" {
+		t.Errorf("domain trusts mismatch (-want got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]link{{GUID: data.Gpos[0].ObjectIdentifier}}, d.Links); diff != "" {
+		t.Errorf("domain links mismatch (-want got):\n%s", diff)
+	}
+	if d.Properties["functionallevel"] != "2016" {
+		t.Errorf("domain functional level = %v", d.Properties["functionallevel"])
+	}
+
+	o := data.OUs[0]
+	if diff := cmp.Diff([]string{domainSID + "-1000"}, o.Computers); diff != "" {
+		t.Errorf("OU computers mismatch (-want got):\n%s", diff)
+	}
+	if o.Properties["blocksinheritance"] != true {
+		t.Errorf("OU blocksinheritance = %v", o.Properties["blocksinheritance"])
+	}
+
+	groups := make(map[string][]member)
+	for _, g := range data.Groups {
+		groups[g.Properties["name"].(string)] = g.Members
+	}
+	wantGroups := map[string][]member{
+		"DOMAIN ADMINS@TESTLAB.LOCAL": {{MemberID: domainSID + "-500", MemberType: "User"}},
+		// resolved from memberOf of the user
+		"HELPDESK@TESTLAB.LOCAL": {{MemberID: domainSID + "-1106", MemberType: "User"}},
+	}
+	if diff := cmp.Diff(wantGroups, groups); diff != "" {
+		t.Errorf("group members mismatch (-want got):\n%s", diff)
+	}
+
+	svc := data.Users[1]
+	if diff := cmp.Diff([]spnTarget{{ComputerSid: domainSID + "-1000", Port: 1434, Service: "SQLAdmin"}}, svc.SPNTargets); diff != "" {
+		t.Errorf("user SPN targets mismatch (-want got):\n%s", diff)
+	}
+	if svc.Properties["description"] != "SQL service account ✓" || svc.Properties["useraccountcontrol"] != int64(512) {
+		t.Errorf("user properties = %v", svc.Properties)
+	}
+	if _, ok := svc.Properties["userpassword"]; ok {
+		t.Error("user password is uploaded")
+	}
+
+	c := data.Computers[0]
+	if c.Properties["name"] != "DC01.TESTLAB.LOCAL" || c.PrimaryGroupSid != domainSID+"-516" {
+		t.Errorf("computer = %v primary group %s", c.Properties, c.PrimaryGroupSid)
+	}
+}
+
+func Test_convertDirectory_allowedToDelegate(t *testing.T) {
+	input := `dn: CN=web,CN=Computers,DC=testlab,DC=local
+objectClass: computer
+objectSid: S-1-5-21-1-2-3-1000
+sAMAccountName: WEB$
+dNSHostName: web.testlab.local
+userAccountControl: 16781312
+msDS-AllowedToDelegateTo: cifs/files.testlab.local
+msDS-AllowedToDelegateTo: cifs/FILES
+msDS-AllowedToDelegateTo: http/app.testlab.local
+msDS-AllowedToDelegateTo: http/unknown.testlab.local
+
+dn: CN=files,CN=Computers,DC=testlab,DC=local
+objectClass: computer
+objectSid: S-1-5-21-1-2-3-1001
+sAMAccountName: FILES$
+dNSHostName: files.testlab.local
+
+dn: CN=svc-app,CN=Users,DC=testlab,DC=local
+objectClass: user
+objectSid: S-1-5-21-1-2-3-1105
+sAMAccountName: svc-app
+servicePrincipalName: http/app.testlab.local
+`
+	entries, err := readLDIF(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := convertDirectory(entries, nil)
+
+	want := []delegateTarget{
+		{ObjectIdentifier: "S-1-5-21-1-2-3-1001", ObjectType: "Computer", SPNs: []string{"cifs/files.testlab.local", "cifs/FILES"}},
+		{ObjectIdentifier: "S-1-5-21-1-2-3-1105", ObjectType: "User", SPNs: []string{"http/app.testlab.local"}},
+	}
+	if diff := cmp.Diff(want, data.Computers[0].AllowedToDelegate); diff != "" {
+		t.Errorf("AllowedToDelegate mismatch (-want got):\n%s", diff)
+	}
+	if !trustedToAuth(data.Computers[0].Properties) {
+		t.Error("expected computer to be trusted to authenticate for delegation")
+	}
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
-	"strings"
-	"sync"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -35,16 +36,87 @@ func main() {
 			EnvVars: []string{"BHI_NEO4J_USERNAME"},
 			Value:   "neo4j",
 		},
+		// password is only required by commands which connect to neo4j
 		&cli.StringFlag{
-			Name:     "bhi-neo4j-password",
-			EnvVars:  []string{"BHI_NEO4J_PASSWORD"},
-			Required: true,
+			Name:    "bhi-neo4j-password",
+			EnvVars: []string{"BHI_NEO4J_PASSWORD"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "bhi-target-directory",
+			EnvVars: []string{"BHI_TARGET_DIRECTORY"},
+			Usage:   "folder used as 'OutputDirectory' for sharphound or as target for uploading Bloodhound json file. can be specified multiple times in upload only mode, first folder is used by sharphound",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-s3-endpoint",
+			EnvVars: []string{"BHI_S3_ENDPOINT"},
+			Usage:   "endpoint of S3 compatible object storage used for 's3://bucket/key' inputs",
+			Value:   "https://s3.amazonaws.com",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-s3-region",
+			EnvVars: []string{"BHI_S3_REGION", "AWS_REGION"},
+			Value:   "us-east-1",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-s3-access-key",
+			EnvVars: []string{"BHI_S3_ACCESS_KEY", "AWS_ACCESS_KEY_ID"},
+			Usage:   "access key of S3 compatible object storage, requests are not signed if not set",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-s3-secret-key",
+			EnvVars: []string{"BHI_S3_SECRET_KEY", "AWS_SECRET_ACCESS_KEY"},
+		},
+		&cli.BoolFlag{
+			Name:  "bhi-recursive",
+			Usage: "search for files in sub folders of target directories",
+		},
+		&cli.StringSliceFlag{
+			Name:  "bhi-include",
+			Usage: "glob pattern of files to upload. pattern without '/' is matched against file name, otherwise against path relative to target directory",
+			Value: cli.NewStringSlice("*.json"),
+		},
+		&cli.StringSliceFlag{
+			Name:  "bhi-exclude",
+			Usage: "glob pattern of files to skip, matched same way as '--bhi-include'",
+		},
+		&cli.StringFlag{
+			Name:  "bhi-collector",
+			Usage: "collector used when not in upload only mode, 'sharphound' (Windows only) or 'ldap'",
+			Value: "sharphound",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-ldap-url",
+			EnvVars: []string{"BHI_LDAP_URL"},
+			Usage:   "url of domain controller used by ldap collector e.g. 'ldaps://dc01.testlab.local'",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-ldap-username",
+			EnvVars: []string{"BHI_LDAP_USERNAME"},
+			Usage:   "bind user of ldap collector e.g. 'user@testlab.local', anonymous bind is used if not set",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-ldap-password",
+			EnvVars: []string{"BHI_LDAP_PASSWORD"},
 		},
 		&cli.StringFlag{
-			Name:     "bhi-target-directory",
-			EnvVars:  []string{"BHI_TARGET_DIRECTORY"},
-			Usage:    "folder used as 'OutputDirectory' for sharphound or as target for uploading Bloodhound json file",
-			Required: true,
+			Name:  "bhi-ldap-base-dn",
+			Usage: "base DN searched by ldap collector, defaults to 'defaultNamingContext' of the server",
+		},
+		&cli.BoolFlag{
+			Name:  "bhi-ldap-insecure-skip-verify",
+			Usage: "skip verification of ldaps server certificate",
+		},
+		&cli.BoolFlag{
+			Name:  "bhi-ldap-start-tls",
+			Usage: "upgrade 'ldap://' connection with StartTLS before bind",
+		},
+		&cli.BoolFlag{
+			Name:  "bhi-ldap-allow-insecure-bind",
+			Usage: "allow simple bind over unencrypted 'ldap://' connection, password is sent in cleartext",
+		},
+		&cli.UintFlag{
+			Name:  "bhi-ldap-page-size",
+			Value: 500,
 		},
 		&cli.BoolFlag{
 			Name:  "bhi-upload-only",
@@ -54,10 +126,74 @@ func main() {
 			Name:  "bhi-delete-exiting-data",
 			Usage: "before uploading new data ALL existing data from database will be deleted",
 		},
+		&cli.BoolFlag{
+			Name:  "bhi-resume",
+			Usage: "skip batches already committed by previous interrupted run, progress is tracked in checkpoint file in '--bhi-target-directory'",
+		},
 		&cli.BoolFlag{
 			Name:  "bhi-delete-json-file",
 			Usage: "delete sharphound json file after upload",
 		},
+		&cli.BoolFlag{
+			Name:  "bhi-fail-fast",
+			Usage: "stop processing remaining files on first error",
+		},
+		&cli.DurationFlag{
+			Name:  "bhi-shutdown-grace-period",
+			Usage: "on shutdown, time given to in-flight batch to be committed before its transaction is rolled back",
+			Value: 30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "bhi-timestamp-format",
+			Usage: "format of timestamp properties (lastlogon, pwdlastset...), 'epoch' for integer unix time or 'datetime' for neo4j DateTime",
+			Value: timestampEpoch,
+		},
+		&cli.StringSliceFlag{
+			Name:  "bhi-property-allow",
+			Usage: "only upload matching node properties, 'Label:pattern' or 'pattern' for all labels e.g. 'User:email' or 'ms-*'",
+		},
+		&cli.StringSliceFlag{
+			Name:  "bhi-property-deny",
+			Usage: "don't upload matching node properties, same format as '--bhi-property-allow'",
+		},
+		&cli.StringSliceFlag{
+			Name:  "bhi-property-redact",
+			Usage: "replace value of matching node properties with 'REDACTED', same format as '--bhi-property-allow'",
+		},
+		&cli.StringSliceFlag{
+			Name:  "bhi-property-hash",
+			Usage: "replace value of matching node properties with its sha256 hash, same format as '--bhi-property-allow'",
+		},
+		&cli.StringFlag{
+			Name:    "bhi-property-hash-salt",
+			EnvVars: []string{"BHI_PROPERTY_HASH_SALT"},
+			Usage:   "key of HMAC-SHA256 used to hash properties, plain sha256 is used if not set",
+		},
+		&cli.BoolFlag{
+			Name:  "bhi-gpo-inheritance",
+			Value: true,
+			Usage: "apply local group memberships granted by GPOs to computers in child OUs, respecting enforced links and blocked inheritance",
+		},
+		&cli.BoolFlag{
+			Name:  "bhi-acl-inheritance-source",
+			Usage: "after upload set 'inheritedfrom' of inherited ACE edges to the container they are inherited from",
+		},
+		&cli.IntFlag{
+			Name:  "bhi-session-expiry",
+			Usage: "after upload delete HasSession edges which weren't seen in given number of days, 0 keeps all sessions",
+		},
+		&cli.StringFlag{
+			Name:  "bhi-tiering-config",
+			Usage: "yaml or json file with rules which set 'tier', 'highvalue' and custom labels of matching nodes after upload",
+		},
+		&cli.StringSliceFlag{
+			Name:  "bhi-owned-file",
+			Usage: "after upload set 'owned' of principals listed in the file, one name, SID or sAMAccountName per line",
+		},
+		&cli.StringFlag{
+			Name:  "bhi-owned-note",
+			Usage: "note stored in 'ownednote' of principals marked by '--bhi-owned-file'",
+		},
 		&cli.StringFlag{
 			Name:  "bhi-logfile",
 			Usage: "location of log file",
@@ -66,70 +202,131 @@ func main() {
 			Name:  "bhi-log-level",
 			Value: "info",
 		},
+		&cli.StringFlag{
+			Name:  "bhi-log-format",
+			Usage: "format of the logs, 'text' or 'json'",
+			Value: "text",
+		},
 	}
 	// Add sharpHound Flags
 	app.Flags = append(app.Flags, sharpHoundFlags...)
 
+	app.ArgsUsage = "[files or urls...]"
+
+	// logging is configured for import and all commands
+	app.Before = configureLogging
+
+	app.Commands = []*cli.Command{
+		analyzeCommand(),
+		diffCommand(),
+		markOwnedCommand(),
+	}
+
 	app.Action = func(c *cli.Context) (err error) {
-		wp := &sync.WaitGroup{}
-		wc := &sync.WaitGroup{}
-		cypherChan := make(chan map[string]*cypher)
+		cypherChan := make(chan *batch)
 
 		ctx, cancel := context.WithCancel(c.Context)
 		defer cancel()
 
-		log.SetFormatter(&logrus.TextFormatter{
-			FullTimestamp: true,
-			DisableQuote:  true,
-		})
+		if err := setTimestampFormat(c.String("bhi-timestamp-format")); err != nil {
+			return err
+		}
 
-		if c.String("bhi-logfile") != "" {
-			file, err := os.OpenFile(c.String("bhi-logfile"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-			if err == nil {
-				log.Out = file
-			} else {
-				log.Info("Failed to log to file, using default stdout")
+		policy, err = newPropertyPolicy(
+			c.StringSlice("bhi-property-allow"),
+			c.StringSlice("bhi-property-deny"),
+			c.StringSlice("bhi-property-redact"),
+			c.StringSlice("bhi-property-hash"),
+			c.String("bhi-property-hash-salt"),
+		)
+		if err != nil {
+			return err
+		}
+
+		var ldapCfg ldapConfig
+		collector := ""
+		if !c.Bool("bhi-upload-only") {
+			collector = c.String("bhi-collector")
+		}
+		switch collector {
+		case "":
+			if len(c.StringSlice("bhi-target-directory")) == 0 && c.Args().Len() == 0 {
+				return fmt.Errorf("either '--bhi-target-directory' or input files must be specified")
+			}
+		case "sharphound":
+			if len(c.StringSlice("bhi-target-directory")) == 0 {
+				return fmt.Errorf("'--bhi-target-directory' is required to run sharphound")
+			}
+		case "ldap":
+			if c.String("bhi-ldap-url") == "" {
+				return fmt.Errorf("'--bhi-ldap-url' is required to run ldap collector")
+			}
+			ldapCfg = ldapConfig{
+				url:                c.String("bhi-ldap-url"),
+				username:           c.String("bhi-ldap-username"),
+				password:           c.String("bhi-ldap-password"),
+				baseDN:             c.String("bhi-ldap-base-dn"),
+				insecureSkipVerify: c.Bool("bhi-ldap-insecure-skip-verify"),
+				startTLS:           c.Bool("bhi-ldap-start-tls"),
+				allowInsecureBind:  c.Bool("bhi-ldap-allow-insecure-bind"),
+				pageSize:           uint32(c.Uint("bhi-ldap-page-size")),
+			}
+			if err := ldapCfg.validate(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported collector %q", collector)
+		}
+
+		if c.Int("bhi-session-expiry") < 0 {
+			return fmt.Errorf("'--bhi-session-expiry' can't be negative")
+		}
+
+		var tiering *tieringConfig
+		if f := c.String("bhi-tiering-config"); f != "" {
+			if tiering, err = loadTieringConfig(f); err != nil {
+				return err
 			}
 		}
 
-		level, err := logrus.ParseLevel(c.String("bhi-log-level"))
+		owned, err := readOwnedFiles(c.StringSlice("bhi-owned-file"))
 		if err != nil {
-			log.Error("unable to parse loglevel argument, setting loglevel to 'Info'")
-			log.SetLevel(logrus.InfoLevel)
-		} else {
-			log.SetLevel(level)
+			return err
 		}
 
-		log.Infof("connecting to %s", c.String("bhi-neo4j-url"))
-		driver, err := neo4j.NewDriver(c.String("bhi-neo4j-url"),
-			neo4j.BasicAuth(c.String("bhi-neo4j-username"), c.String("bhi-neo4j-password"), ""),
-			func(config *neo4j.Config) {
-				config.Log = neo4j.ConsoleLogger(neo4j.ERROR)
-			},
-		)
+		if c.Bool("bhi-resume") && c.Bool("bhi-delete-exiting-data") {
+			return fmt.Errorf("'--bhi-resume' can't be used with '--bhi-delete-exiting-data'")
+		}
+
+		driver, err := connect(c)
 		if err != nil {
 			return err
 		}
 		defer driver.Close()
 
-		if err := driver.VerifyConnectivity(); err != nil {
-			log.Error("unable to verify connectivity")
-			return err
+		// name patterns are validated before upload so invalid config doesn't fail a finished upload
+		if tiering != nil {
+			if err := tiering.validatePatterns(driver); err != nil {
+				if isNeo4jConnectivityError(err) {
+					return connectivityError(err)
+				}
+				return err
+			}
 		}
 
 		// Delete existing data from DB if flag is set
 		if c.Bool("bhi-delete-exiting-data") {
 			total, err := deleteExistingData(driver)
 			if err != nil {
-				log.Errorf("unable to delete existing data from database %s", err)
+				log.WithError(err).Error("unable to delete existing data from database")
 			}
-			log.Infof("deleted %d existing nodes from database", total)
+			log.WithField("rows", total).Info("deleted existing nodes from database")
 		}
 
 		// graceful shutdown when terminate signal received.
 		go gracefulShutdown(cancel)
 
-		if !c.Bool("bhi-upload-only") {
+		if collector == "sharphound" {
 			log.Infof("loading and running sharphound...")
 			err = execSharpHound(ctx, c)
 			if err != nil {
@@ -138,60 +335,240 @@ func main() {
 		}
 
 		log.Infof("starting DB upload...")
-		// Get all json file from source folder
-		files, err := getFileNames(c.String("bhi-target-directory"))
+		// Get all input files from target folders and args
+		dirs := c.StringSlice("bhi-target-directory")
+		s3, err := newS3Client(c.String("bhi-s3-endpoint"), c.String("bhi-s3-region"), c.String("bhi-s3-access-key"), c.String("bhi-s3-secret-key"))
+		if err != nil {
+			return err
+		}
+		src := &inputSource{s3: s3}
+
+		files, err := findInputFiles(ctx, src, dirs, c.Args().Slice(), inputOptions{
+			recursive: c.Bool("bhi-recursive"),
+			include:   c.StringSlice("bhi-include"),
+			exclude:   c.StringSlice("bhi-exclude"),
+		})
 		if err != nil {
 			return err
 		}
 
+		cp, err := loadCheckpoint(filepath.Join(checkpointDir(dirs, c.Args().Slice()), checkpointFileName), c.Bool("bhi-resume"))
+		if err != nil {
+			return fmt.Errorf("unable to load checkpoint %w", err)
+		}
+
+		// collected data is uploaded along with the files
+		inputs := files
+		if collector == "ldap" {
+			inputs = append(inputs, c.String("bhi-ldap-url"))
+		}
+		summary := newImportSummary(inputs)
+		barrier := newInputBarrier(inputs)
+
+		var tree *gpoTree
+		if c.Bool("bhi-gpo-inheritance") {
+			tree = newGPOTree()
+		}
+
 		// start uploader
 		// since user's and computer's nodes are mixed in many files only one uploader is used
 		// multiple uploader will cause conflicts while adding nodes on neo4j
-		wc.Add(1)
-		go func() {
-			err := uploadData(wc, driver, cypherChan)
+		// processors are stopped on first error if fail fast is set
+		// and always when uploader fails as nothing more can be uploaded.
+		// uploader runs on parent context so batches already queued are uploaded
+		// when processors finish or are stopped
+		processors, pctx := newErrorGroup(ctx, c.Bool("bhi-fail-fast"))
+		uploader, _ := newErrorGroup(ctx, false)
+
+		uploader.Go(func() error {
+			err := uploadData(ctx, c.Duration("bhi-shutdown-grace-period"), driver, cypherChan, cp, summary)
 			if err != nil {
-				log.Fatalf("error uploading data %s", err)
+				log.WithError(err).Error("error uploading data")
+				processors.stop()
 			}
-		}()
+			return err
+		})
 
 		// start data/file processors
+		process := func(input string, fn func() error) {
+			processors.Go(func() error {
+				// failed inputs don't hold back custom data of other inputs
+				defer barrier.arrive(input)
+				summary.started(input)
+				err := fn()
+				if err == nil {
+					return nil
+				}
+				summary.failed(input, err)
+				// cancelled inputs are reported in summary
+				if errors.Is(err, context.Canceled) {
+					return nil
+				}
+				log.WithField("file", input).WithError(err).Error("error processing file")
+				return fmt.Errorf("%s: %w", input, err)
+			})
+		}
 		for _, f := range files {
-			wp.Add(1)
-			go func(f string) {
-				err := processData(ctx, wp, f, cypherChan, c.Bool("bhi-delete-json-file"))
+			f := f
+			process(f, func() error {
+				return processData(pctx, src, f, cypherChan, cp, summary, tree, barrier)
+			})
+		}
+		if collector == "ldap" {
+			process(ldapCfg.url, func() error {
+				data, hash, err := collectLDAP(pctx, ldapCfg)
 				if err != nil {
-					log.Errorf("error processing %s - %s", f, err)
+					return err
 				}
-			}(f)
+				return queueData(pctx, ldapCfg.url, hash, data, data.contentTypes(), cypherChan, cp, summary, tree, barrier)
+			})
 		}
 
 		// wait for producer and uploader to finish
-		wp.Wait()
+		errs := processors.Wait()
 		// close channel and wait for uploader
 		close(cypherChan)
-		wc.Wait()
+		errs = append(errs, uploader.Wait()...)
+
+		// inherited memberships are only resolved once OUs and domains of all inputs are uploaded
+		if len(errs) == 0 && ctx.Err() == nil {
+			if err := uploadGPOInheritance(ctx, driver, tree); err != nil {
+				log.WithError(err).Error("error uploading GPO inheritance")
+				errs = append(errs, err)
+			}
+		}
+		if days := c.Int("bhi-session-expiry"); days > 0 && len(errs) == 0 && ctx.Err() == nil {
+			deleted, err := expireSessions(driver, days)
+			if err != nil {
+				log.WithError(err).Error("unable to delete expired sessions")
+				errs = append(errs, uploadError(err))
+			} else {
+				log.WithField("sessions", deleted).Infof("deleted sessions not seen in %d days", days)
+			}
+		}
+		if c.Bool("bhi-acl-inheritance-source") && len(errs) == 0 && ctx.Err() == nil {
+			resolved, err := resolveACLInheritance(driver)
+			if err != nil {
+				log.WithError(err).Error("unable to resolve source of inherited ACEs")
+				errs = append(errs, uploadError(err))
+			} else {
+				log.WithField("edges", resolved).Info("resolved source of inherited ACEs")
+			}
+		}
+		// tiering is applied once all inputs are uploaded as group memberships span multiple inputs
+		if tiering != nil && len(errs) == 0 && ctx.Err() == nil {
+			matched, err := applyTiering(driver, tiering)
+			if err != nil {
+				log.WithError(err).Error("unable to apply tiering config")
+				errs = append(errs, uploadError(err))
+			}
+			for i, n := range matched {
+				log.WithField("rule", tiering.Rules[i].Name).WithField("nodes", n).Info("applied tiering rule")
+			}
+		}
+		if len(owned) > 0 && len(errs) == 0 && ctx.Err() == nil {
+			if err := markOwnedEntries(driver, owned, c.String("bhi-owned-note")); err != nil {
+				log.WithError(err).Error("unable to mark owned principals")
+				errs = append(errs, err)
+			}
+		}
+
+		notLoaded := summary.log()
+		if notLoaded == 0 && len(errs) == 0 {
+			if err := cp.clear(); err != nil {
+				log.WithError(err).Error("unable to remove checkpoint")
+			}
+		}
+
+		// only delete local files which were completely uploaded
+		if c.Bool("bhi-delete-json-file") {
+			loaded, _ := summary.result()
+			for _, f := range loaded {
+				// collected data isn't a file
+				if !isLocalFile(f) || (collector == "ldap" && f == c.String("bhi-ldap-url")) {
+					continue
+				}
+				if err := os.Remove(f); err != nil {
+					log.WithField("file", f).WithError(err).Error("unable to delete file")
+				}
+			}
+		}
+
+		switch {
+		case len(errs) > 0:
+			return &importError{
+				code: exitCode(errs...),
+				err:  fmt.Errorf("%d error(s) during import, %d of %d files were not fully loaded", len(errs), notLoaded, len(inputs)),
+			}
+		case notLoaded > 0 && ctx.Err() != nil:
+			return partialUploadError(fmt.Errorf("import interrupted, %d of %d files were not fully loaded", notLoaded, len(inputs)))
+		case notLoaded > 0:
+			return partialUploadError(fmt.Errorf("%d of %d files were not fully loaded", notLoaded, len(inputs)))
+		}
 		return nil
 	}
 
 	if err := app.Run(os.Args); err != nil {
 		log.Error(err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
-func getFileNames(dir string) ([]string, error) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, err
+// configureLogging sets format, output and level of the logs
+func configureLogging(c *cli.Context) error {
+	switch c.String("bhi-log-format") {
+	case "json":
+		log.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		log.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+			DisableQuote:  true,
+		})
+	default:
+		return fmt.Errorf("unsupported log format %q", c.String("bhi-log-format"))
 	}
-	var jsonFiles []string
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			jsonFiles = append(jsonFiles, dir+"/"+file.Name())
+
+	if c.String("bhi-logfile") != "" {
+		file, err := os.OpenFile(c.String("bhi-logfile"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err == nil {
+			log.Out = file
+		} else {
+			log.Info("Failed to log to file, using default stdout")
 		}
 	}
-	return jsonFiles, nil
+
+	level, err := logrus.ParseLevel(c.String("bhi-log-level"))
+	if err != nil {
+		log.Error("unable to parse loglevel argument, setting loglevel to 'Info'")
+		log.SetLevel(logrus.InfoLevel)
+	} else {
+		log.SetLevel(level)
+	}
+	return nil
+}
+
+// connect creates neo4j driver from '--bhi-neo4j-*' flags and verifies connectivity
+func connect(c *cli.Context) (neo4j.Driver, error) {
+	if c.String("bhi-neo4j-password") == "" {
+		return nil, fmt.Errorf("'--bhi-neo4j-password' is required")
+	}
+	log.Infof("connecting to %s", c.String("bhi-neo4j-url"))
+	driver, err := neo4j.NewDriver(c.String("bhi-neo4j-url"),
+		neo4j.BasicAuth(c.String("bhi-neo4j-username"), c.String("bhi-neo4j-password"), ""),
+		func(config *neo4j.Config) {
+			config.Log = neo4j.ConsoleLogger(neo4j.ERROR)
+		},
+	)
+	if err != nil {
+		return nil, connectivityError(err)
+	}
+
+	if err := driver.VerifyConnectivity(); err != nil {
+		log.Error("unable to verify connectivity")
+		driver.Close()
+		return nil, connectivityError(err)
+	}
+	return driver, nil
 }
 
 func gracefulShutdown(cancel context.CancelFunc) {
@@ -201,6 +578,10 @@ func gracefulShutdown(cancel context.CancelFunc) {
 	log.Info("Shutting down...")
 	// cancel context
 	cancel()
+	// second signal terminates app immediately
+	<-sCh
+	log.Error("forced shutdown")
+	os.Exit(1)
 }
 
 func deleteExistingData(driver neo4j.Driver) (int64, error) {
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// supported meta types in order of dependency, custom data is imported last
+// so its edges can refer to directory objects by name
+var metaTypes = []string{"domains", "ous", "gpos", "groups", "users", "computers", metaTypeNodes, metaTypeEdges}
+
+// matches SharpHound file names like '20210301_users.json' as well as 'users.json'
+var fileTypeRegex = regexp.MustCompile(`(?:^|[_\-.])(users|computers|groups|ous|gpos|domains|nodes|edges)$`)
+
+// normaliseMetaType converts meta type to one of metaTypes,
+// it accepts any case and singular form. empty string is returned for unknown types
+func normaliseMetaType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	for _, mt := range metaTypes {
+		if t == mt || t+"s" == mt {
+			return mt
+		}
+	}
+	return ""
+}
+
+// contentTypes returns meta types of all populated top level arrays
+func (data *bloodHoundRawData) contentTypes() []string {
+	counts := map[string]int{
+		"domains":   len(data.Domains),
+		"ous":       len(data.OUs),
+		"gpos":      len(data.Gpos),
+		"groups":    len(data.Groups),
+		"users":     len(data.Users),
+		"computers": len(data.Computers),
+		"nodes":     len(data.Nodes),
+		"edges":     len(data.Edges),
+	}
+	var types []string
+	for _, mt := range metaTypes {
+		if counts[mt] > 0 {
+			types = append(types, mt)
+		}
+	}
+	return types
+}
+
+// fileNameType returns meta type based on file name convention, e.g. '20210301_users.json'
+func fileNameType(name string) string {
+	base := inputBase(name)
+	base = strings.ToLower(strings.TrimSuffix(base, path.Ext(base)))
+	if m := fileTypeRegex.FindStringSubmatch(base); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// detectMetaType returns type of the data in the file. populated top level
+// array is preferred over meta block as hand edited files and third party
+// collectors often have missing or wrong meta, file name is used when content
+// is ambiguous. returned warnings describe any disagreement
+func detectMetaType(name string, data *bloodHoundRawData) (string, []string, error) {
+	var warnings []string
+	metaType := normaliseMetaType(data.Meta.Type)
+	nameType := fileNameType(name)
+	content := data.contentTypes()
+
+	if data.Meta.Type != "" && metaType == "" {
+		warnings = append(warnings, fmt.Sprintf("unknown meta type %q", data.Meta.Type))
+	}
+
+	detected := metaType
+	switch {
+	case len(content) == 0:
+		// nothing to import, use whatever is known
+		if detected == "" {
+			detected = nameType
+		}
+	case contains(content, metaType):
+		// meta matches content
+	case len(content) == 1:
+		detected = content[0]
+		if metaType != "" {
+			warnings = append(warnings, fmt.Sprintf("meta type %q doesn't match content %q", metaType, detected))
+		}
+	case contains(content, nameType):
+		detected = nameType
+		warnings = append(warnings, fmt.Sprintf("file contains multiple types %v, using %q based on file name", content, detected))
+	default:
+		return "", warnings, fmt.Errorf("unable to detect type of data, meta type %q, file contains %v", data.Meta.Type, content)
+	}
+
+	if detected == "" {
+		return "", warnings, fmt.Errorf("unable to detect type of data, meta type %q", data.Meta.Type)
+	}
+	if nameType != "" && nameType != detected {
+		warnings = append(warnings, fmt.Sprintf("file name suggests type %q but %q is used", nameType, detected))
+	}
+	return detected, warnings, nil
+}
+
+// dataTypes returns types of data to import. data converted from directory dumps
+// and custom data contain multiple types, type of any other data is detected
+func dataTypes(name string, data *bloodHoundRawData) ([]string, []string, error) {
+	content := data.contentTypes()
+	custom := len(content) > 0
+	for _, t := range content {
+		if t != metaTypeNodes && t != metaTypeEdges {
+			custom = false
+		}
+	}
+	if data.Meta.Type == metaTypeAll || custom {
+		return content, nil, nil
+	}
+	metaType, warnings, err := detectMetaType(name, data)
+	if err != nil {
+		return nil, warnings, err
+	}
+	return []string{metaType}, warnings, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
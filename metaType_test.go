@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_detectMetaType(t *testing.T) {
+	users := []user{{ObjectIdentifier: "S-1-5-21-1-500"}}
+	groups := []group{{ObjectIdentifier: "S-1-5-21-1-512"}}
+
+	tests := []struct {
+		name         string
+		file         string
+		data         bloodHoundRawData
+		want         string
+		wantWarnings int
+		wantErr      bool
+	}{
+		{name: "meta matches content", file: "20210301_users.json", data: bloodHoundRawData{Users: users, Meta: meta{Type: "users"}}, want: "users"},
+		{name: "odd case and singular meta", file: "data.json", data: bloodHoundRawData{Users: users, Meta: meta{Type: " User"}}, want: "users"},
+		{name: "missing meta", file: "data.json", data: bloodHoundRawData{Groups: groups}, want: "groups"},
+		{name: "meta disagrees with content", file: "data.json", data: bloodHoundRawData{Groups: groups, Meta: meta{Type: "users"}}, want: "groups", wantWarnings: 1},
+		{name: "unknown meta", file: "data.json", data: bloodHoundRawData{Groups: groups, Meta: meta{Type: "adexplorer"}}, want: "groups", wantWarnings: 1},
+		{name: "file name disagrees", file: "x_users.json", data: bloodHoundRawData{Groups: groups, Meta: meta{Type: "groups"}}, want: "groups", wantWarnings: 1},
+		{name: "empty file uses name", file: "https://host/bh/20210301_computers.json", want: "computers"},
+		{name: "signed url uses name", file: "https://host/bh/20210301_computers.json?X-Amz-Signature=abc.json", want: "computers"},
+		{name: "mixed content uses name", file: "x_users.json", data: bloodHoundRawData{Users: users, Groups: groups}, want: "users", wantWarnings: 1},
+		{name: "mixed content without hint", file: "data.json", data: bloodHoundRawData{Users: users, Groups: groups}, wantErr: true},
+		{name: "empty file without hint", file: "data.json", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings, err := detectMetaType(tt.file, &tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("detectMetaType() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("detectMetaType() mismatch (-want got):\n%s", diff)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("detectMetaType() warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
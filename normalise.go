@@ -0,0 +1,210 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// node labels which can be used as PrincipalType/MemberType
+var knownLabels = map[string]string{
+	"user":     "User",
+	"computer": "Computer",
+	"group":    "Group",
+	"domain":   "Domain",
+	"gpo":      "GPO",
+	"ou":       "OU",
+	"base":     "Base",
+}
+
+// ACE right names and types used by addACECyphers
+var knownRights = map[string]string{}
+
+func init() {
+	for _, r := range []string{
+		"GenericAll", "WriteDacl", "WriteOwner", "GenericWrite", "Owner", "ReadLAPSPassword",
+		"ReadGMSAPassword", "ExtendedRight", "All", "User-Force-Change-Password", "AddMember",
+		"AllowedToAct", "GetChanges", "GetChangesAll", "WriteProperty",
+	} {
+		knownRights[strings.ToLower(r)] = r
+	}
+}
+
+// well known SIDs which are not unique across domains, SharpHound prefixes them
+// with domain name e.g. 'TESTLAB.LOCAL-S-1-5-32-544'
+var wellKnownSIDRegex = regexp.MustCompile(`^S-1-(0|1|2|3|5-(\d|1\d|32|64|65|80|113|114))(-\d+)?$`)
+
+// normaliseLabel returns canonical node label, unknown labels are mapped to
+// 'Base' as labels are used in cypher statements
+func normaliseLabel(l string) string {
+	if label, ok := knownLabels[strings.ToLower(strings.TrimSpace(l))]; ok {
+		return label
+	}
+	return "Base"
+}
+
+func normaliseRight(r string) string {
+	if right, ok := knownRights[strings.ToLower(r)]; ok {
+		return right
+	}
+	return r
+}
+
+// normaliseID uppercases SIDs and GUIDs including domain prefix of well known
+// SIDs, bare well known SIDs are prefixed with the domain name same as SharpHound does
+func normaliseID(id, domain string) string {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	if domain != "" && wellKnownSIDRegex.MatchString(id) {
+		return strings.ToUpper(domain) + "-" + id
+	}
+	return id
+}
+
+// normaliseProperties lowercases property keys, lowercase key wins if
+// property is present in multiple forms
+func normaliseProperties(props map[string]interface{}) map[string]interface{} {
+	if props == nil {
+		return nil
+	}
+	normalised := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		lk := strings.ToLower(k)
+		if _, ok := normalised[lk]; ok && k != lk {
+			continue
+		}
+		normalised[lk] = v
+	}
+	return normalised
+}
+
+// objectIdentifier returns identifier of the object, third party collectors
+// like BloodHound.py don't always set ObjectIdentifier so properties are used
+func objectIdentifier(id string, props map[string]interface{}) string {
+	if id != "" {
+		return id
+	}
+	for _, k := range []string{"objectid", "objectsid", "objectguid"} {
+		if v, ok := props[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func propertyDomain(props map[string]interface{}) string {
+	d, _ := props["domain"].(string)
+	return d
+}
+
+func normaliseAces(aces []ace, domain string) {
+	for i := range aces {
+		aces[i].PrincipalSID = normaliseID(aces[i].PrincipalSID, domain)
+		aces[i].PrincipalType = normaliseLabel(aces[i].PrincipalType)
+		aces[i].RightName = normaliseRight(aces[i].RightName)
+		aces[i].AceType = normaliseRight(aces[i].AceType)
+	}
+}
+
+func normaliseMembers(members []member, domain string) {
+	for i := range members {
+		members[i].MemberID = normaliseID(members[i].MemberID, domain)
+		members[i].MemberType = normaliseLabel(members[i].MemberType)
+	}
+}
+
+func normaliseIDs(ids []string, domain string) {
+	for i := range ids {
+		ids[i] = normaliseID(ids[i], domain)
+	}
+}
+
+// normaliseDelegateTargets normalises objectids and types of delegation targets, unknown type is kept empty
+func normaliseDelegateTargets(targets []delegateTarget, domain string) {
+	for i := range targets {
+		targets[i].ObjectIdentifier = normaliseID(targets[i].ObjectIdentifier, domain)
+		if targets[i].ObjectType != "" {
+			targets[i].ObjectType = normaliseLabel(targets[i].ObjectType)
+		}
+	}
+}
+
+// normalise converts data produced by other collectors (e.g. BloodHound.py)
+// to the same shape as SharpHound data. identifiers are uppercased,
+// property keys lowercased and principal types converted to node labels
+func (data *bloodHoundRawData) normalise() {
+	for i := range data.Users {
+		o := &data.Users[i]
+		o.Properties = normaliseProperties(o.Properties)
+		d := propertyDomain(o.Properties)
+		o.ObjectIdentifier = normaliseID(objectIdentifier(o.ObjectIdentifier, o.Properties), d)
+		o.PrimaryGroupSid = normaliseID(o.PrimaryGroupSid, d)
+		normaliseDelegateTargets(o.AllowedToDelegate, d)
+		normaliseMembers(o.HasSIDHistory, d)
+		normaliseAces(o.Aces, d)
+		for j := range o.SPNTargets {
+			o.SPNTargets[j].ComputerSid = normaliseID(o.SPNTargets[j].ComputerSid, d)
+		}
+	}
+	for i := range data.Computers {
+		o := &data.Computers[i]
+		o.Properties = normaliseProperties(o.Properties)
+		d := propertyDomain(o.Properties)
+		o.ObjectIdentifier = normaliseID(objectIdentifier(o.ObjectIdentifier, o.Properties), d)
+		o.PrimaryGroupSid = normaliseID(o.PrimaryGroupSid, d)
+		normaliseDelegateTargets(o.AllowedToDelegate, d)
+		for _, m := range [][]member{o.AllowedToAct, o.LocalAdmins, o.RemoteDesktopUsers, o.DcomUsers, o.PSRemoteUsers} {
+			normaliseMembers(m, d)
+		}
+		for _, sessions := range []sessionList{o.Sessions, o.PrivilegedSessions, o.RegistrySessions} {
+			for j := range sessions {
+				sessions[j].UserID = normaliseID(sessions[j].UserID, d)
+				sessions[j].ComputerID = normaliseID(sessions[j].ComputerID, d)
+			}
+		}
+		normaliseAces(o.Aces, d)
+	}
+	for i := range data.Groups {
+		o := &data.Groups[i]
+		o.Properties = normaliseProperties(o.Properties)
+		d := propertyDomain(o.Properties)
+		o.ObjectIdentifier = normaliseID(objectIdentifier(o.ObjectIdentifier, o.Properties), d)
+		normaliseMembers(o.Members, d)
+		normaliseAces(o.Aces, d)
+	}
+	for i := range data.Gpos {
+		o := &data.Gpos[i]
+		o.Properties = normaliseProperties(o.Properties)
+		d := propertyDomain(o.Properties)
+		o.ObjectIdentifier = normaliseID(objectIdentifier(o.ObjectIdentifier, o.Properties), d)
+		normaliseAces(o.Aces, d)
+	}
+	for i := range data.OUs {
+		o := &data.OUs[i]
+		o.Properties = normaliseProperties(o.Properties)
+		d := propertyDomain(o.Properties)
+		o.ObjectIdentifier = normaliseID(objectIdentifier(o.ObjectIdentifier, o.Properties), d)
+		normaliseIDs(o.Users, d)
+		normaliseIDs(o.Computers, d)
+		normaliseIDs(o.ChildOus, d)
+		for _, m := range [][]member{o.LocalAdmins, o.RemoteDesktopUsers, o.DcomUsers, o.PSRemoteUsers} {
+			normaliseMembers(m, d)
+		}
+		normaliseAces(o.Aces, d)
+	}
+	for i := range data.Domains {
+		o := &data.Domains[i]
+		o.Properties = normaliseProperties(o.Properties)
+		d := propertyDomain(o.Properties)
+		o.ObjectIdentifier = normaliseID(objectIdentifier(o.ObjectIdentifier, o.Properties), d)
+		normaliseIDs(o.Users, d)
+		normaliseIDs(o.Computers, d)
+		normaliseIDs(o.ChildOus, d)
+		for _, m := range [][]member{o.LocalAdmins, o.RemoteDesktopUsers, o.DcomUsers, o.PSRemoteUsers} {
+			normaliseMembers(m, d)
+		}
+		for j := range o.Trusts {
+			o.Trusts[j].TargetDomainSid = normaliseID(o.Trusts[j].TargetDomainSid, "")
+			o.Trusts[j].TargetDomainName = strings.ToUpper(o.Trusts[j].TargetDomainName)
+		}
+		normaliseAces(o.Aces, d)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_normalise_bloodhoundPy(t *testing.T) {
+	users, err := parseFile("test_data/bloodhoundpy_users.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := users.Users[0]
+	if u.ObjectIdentifier != "S-1-5-21-3130019616-2776909439-2417379446-1105" {
+		t.Errorf("ObjectIdentifier not taken from properties, got %q", u.ObjectIdentifier)
+	}
+	if u.PrimaryGroupSid != "S-1-5-21-3130019616-2776909439-2417379446-513" {
+		t.Errorf("PrimaryGroupSid not uppercased, got %q", u.PrimaryGroupSid)
+	}
+	if _, ok := u.Properties["name"]; !ok {
+		t.Errorf("property keys not lowercased, got %v", u.Properties)
+	}
+	wantAces := []ace{
+		{PrincipalSID: "TESTLAB.LOCAL-S-1-5-32-548", PrincipalType: "Group", RightName: "GenericAll"},
+		{PrincipalSID: "S-1-5-21-3130019616-2776909439-2417379446-512", PrincipalType: "Group", RightName: "ExtendedRight", AceType: "User-Force-Change-Password", IsInherited: true},
+	}
+	if diff := cmp.Diff(wantAces, u.Aces); diff != "" {
+		t.Errorf("aces mismatch (-want got):\n%s", diff)
+	}
+
+	groups, err := parseFile("test_data/bloodhoundpy_groups.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMembers := []member{
+		{MemberID: "S-1-5-21-3130019616-2776909439-2417379446-1105", MemberType: "User"},
+		{MemberID: "TESTLAB.LOCAL-S-1-5-32-544", MemberType: "Group"},
+	}
+	if diff := cmp.Diff(wantMembers, groups.Groups[0].Members); diff != "" {
+		t.Errorf("members mismatch (-want got):\n%s", diff)
+	}
+
+	computers, err := parseFile("test_data/bloodhoundpy_computers.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := computers.Computers[0]
+	wantSessions := sessionList{{
+		UserID:     "S-1-5-21-3130019616-2776909439-2417379446-1105",
+		ComputerID: "S-1-5-21-3130019616-2776909439-2417379446-1104",
+		Legacy:     true,
+	}}
+	if diff := cmp.Diff(wantSessions, c.Sessions); diff != "" {
+		t.Errorf("sessions mismatch (-want got):\n%s", diff)
+	}
+	if c.LocalAdmins[0].MemberType != "Group" {
+		t.Errorf("MemberType not normalised, got %q", c.LocalAdmins[0].MemberType)
+	}
+}
+
+func Test_normaliseLabel(t *testing.T) {
+	for in, want := range map[string]string{"user": "User", "GPO": "GPO", "ou": "OU", "Group) DETACH DELETE (n": "Base", "": "Base"} {
+		if got := normaliseLabel(in); got != want {
+			t.Errorf("normaliseLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/urfave/cli/v2"
+)
+
+// kinds of entries of owned file
+const (
+	ownedSID     = "sid"
+	ownedName    = "name"
+	ownedAccount = "account"
+)
+
+var sidRegex = regexp.MustCompile(`^(.+-)?S-1-\d+(-\d+)+$`)
+
+// ownedEntry is a principal listed in owned file, it's resolved by objectid, name (USER@DOMAIN)
+// or account, which is user or computer sAMAccountName optionally prefixed with domain (DOMAIN\user) or computer FQDN
+type ownedEntry struct {
+	raw    string
+	kind   string
+	value  string
+	domain string
+}
+
+func parseOwnedEntry(s string) ownedEntry {
+	e := ownedEntry{raw: s, value: strings.ToUpper(s)}
+	switch {
+	case sidRegex.MatchString(e.value):
+		e.kind = ownedSID
+	case strings.Contains(e.value, `\`):
+		i := strings.Index(e.value, `\`)
+		e.kind, e.domain, e.value = ownedAccount, e.value[:i], e.value[i+1:]
+	case strings.Contains(e.value, "@"):
+		e.kind = ownedName
+	default:
+		e.kind = ownedAccount
+	}
+	// computer accounts can be listed by their sAMAccountName
+	if e.kind == ownedAccount {
+		e.value = strings.TrimSuffix(e.value, "$")
+	}
+	return e
+}
+
+// readOwnedFile returns entries of owned file, one per line. empty lines and lines starting with '#' are skipped
+func readOwnedFile(file string) ([]ownedEntry, error) {
+	f, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ownedEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, parseOwnedEntry(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// resolveStatement returns statement which returns objectids of nodes matching the entry.
+// accounts are matched by sAMAccountName, or by name for collectors which don't record it
+func (e ownedEntry) resolveStatement() (string, map[string]interface{}) {
+	params := map[string]interface{}{"value": e.value}
+	switch e.kind {
+	case ownedSID:
+		return "MATCH (n:Base {objectid: $value}) RETURN n.objectid", params
+	case ownedName:
+		return "MATCH (n:Base {name: $value}) RETURN n.objectid", params
+	}
+	statement := "MATCH (n:Base) WHERE (n:User OR n:Computer) AND (n.name = $value OR toUpper(n.samaccountname) IN [$value, $value + '$']" +
+		" OR n.name STARTS WITH $value + '@' OR (n:Computer AND n.name STARTS WITH $value + '.'))"
+	if e.domain != "" {
+		params["domain"] = e.domain
+		statement += " AND (toUpper(n.domain) = $domain OR toUpper(n.domain) STARTS WITH $domain + '.')"
+	}
+	return statement + " RETURN n.objectid", params
+}
+
+// unresolvedEntry is an entry of owned file which wasn't marked
+type unresolvedEntry struct {
+	entry  string
+	reason string
+}
+
+// markOwned resolves entries against the graph and sets 'owned', 'ownednote' and 'ownedat' of resolved nodes.
+// entries which don't match any node or match multiple nodes aren't marked, they are returned with reason
+func markOwned(driver neo4j.Driver, entries []ownedEntry, note string) (int, []unresolvedEntry, error) {
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode: neo4j.AccessModeWrite,
+	})
+	defer session.Close()
+
+	var noteParam interface{}
+	if note != "" {
+		noteParam = note
+	}
+	mark := fmt.Sprintf(`MATCH (n:Base {objectid: $objectid})
+SET n.owned = true, n.ownednote = coalesce($note, n.ownednote), n.ownedat = coalesce(n.ownedat, %s)`, currentTime())
+
+	var unresolved []unresolvedEntry
+	marked, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		marked := 0
+		unresolved = nil
+		for _, e := range entries {
+			st, params := e.resolveStatement()
+			records, err := neo4j.Collect(tx.Run(st, params))
+			if err != nil {
+				return nil, err
+			}
+			switch len(records) {
+			case 0:
+				unresolved = append(unresolved, unresolvedEntry{entry: e.raw, reason: "not found"})
+				continue
+			case 1:
+			default:
+				unresolved = append(unresolved, unresolvedEntry{entry: e.raw, reason: fmt.Sprintf("matches %d nodes", len(records))})
+				continue
+			}
+			if _, err := tx.Run(mark, map[string]interface{}{"objectid": records[0].Values[0], "note": noteParam}); err != nil {
+				return nil, err
+			}
+			marked++
+		}
+		return marked, nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return marked.(int), unresolved, nil
+}
+
+// readOwnedFiles returns entries of all owned files
+func readOwnedFiles(files []string) ([]ownedEntry, error) {
+	var entries []ownedEntry
+	for _, f := range files {
+		e, err := readOwnedFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read owned file %w", err)
+		}
+		entries = append(entries, e...)
+	}
+	return entries, nil
+}
+
+// markOwnedEntries marks entries and logs entries which weren't resolved
+func markOwnedEntries(driver neo4j.Driver, entries []ownedEntry, note string) error {
+	marked, unresolved, err := markOwned(driver, entries, note)
+	if err != nil {
+		return uploadError(err)
+	}
+	for _, u := range unresolved {
+		log.WithField("entry", u.entry).Warnf("unable to resolve owned principal, %s", u.reason)
+	}
+	log.WithField("marked", marked).WithField("unresolved", len(unresolved)).Info("marked owned principals")
+	return nil
+}
+
+func markOwnedCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "mark-owned",
+		Usage:     "set 'owned' of principals listed in files, one name, SID or sAMAccountName per line",
+		ArgsUsage: "[files...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "bhi-owned-note",
+				Usage: "note stored in 'ownednote' of marked principals e.g. how they were compromised",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Args().Len() == 0 {
+				return fmt.Errorf("owned files must be specified")
+			}
+			if err := setTimestampFormat(c.String("bhi-timestamp-format")); err != nil {
+				return err
+			}
+			entries, err := readOwnedFiles(c.Args().Slice())
+			if err != nil {
+				return err
+			}
+			driver, err := connect(c)
+			if err != nil {
+				return err
+			}
+			defer driver.Close()
+			return markOwnedEntries(driver, entries, c.String("bhi-owned-note"))
+		},
+	}
+}
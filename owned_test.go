@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseOwnedEntry(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  ownedEntry
+	}{
+		{entry: "S-1-5-21-1-1105", want: ownedEntry{raw: "S-1-5-21-1-1105", kind: ownedSID, value: "S-1-5-21-1-1105"}},
+		{entry: "testlab.local-s-1-5-32-544", want: ownedEntry{raw: "testlab.local-s-1-5-32-544", kind: ownedSID, value: "TESTLAB.LOCAL-S-1-5-32-544"}},
+		{entry: "alice@testlab.local", want: ownedEntry{raw: "alice@testlab.local", kind: ownedName, value: "ALICE@TESTLAB.LOCAL"}},
+		{entry: "alice", want: ownedEntry{raw: "alice", kind: ownedAccount, value: "ALICE"}},
+		{entry: "john.smith", want: ownedEntry{raw: "john.smith", kind: ownedAccount, value: "JOHN.SMITH"}},
+		{entry: `TESTLAB\WS01$`, want: ownedEntry{raw: `TESTLAB\WS01$`, kind: ownedAccount, value: "WS01", domain: "TESTLAB"}},
+		{entry: "ws01.testlab.local", want: ownedEntry{raw: "ws01.testlab.local", kind: ownedAccount, value: "WS01.TESTLAB.LOCAL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, parseOwnedEntry(tt.entry), cmp.AllowUnexported(ownedEntry{})); diff != "" {
+				t.Errorf("parseOwnedEntry() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_readOwnedFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "owned.txt")
+	if err := ioutil.WriteFile(file, []byte("# phishing\nalice@testlab.local\n\n  S-1-5-21-1-1106  \r\n# kerberoasting\nsvc_sql\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readOwnedFile(file)
+	if err != nil {
+		t.Fatalf("readOwnedFile() error = %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.raw)
+	}
+	if diff := cmp.Diff([]string{"alice@testlab.local", "S-1-5-21-1-1106", "svc_sql"}, got); diff != "" {
+		t.Errorf("readOwnedFile() mismatch (-want got):\n%s", diff)
+	}
+}
+
+func Test_ownedEntry_resolveStatement(t *testing.T) {
+	stmt, params := parseOwnedEntry(`testlab\svc_sql`).resolveStatement()
+	want := "MATCH (n:Base) WHERE (n:User OR n:Computer) AND (n.name = $value OR toUpper(n.samaccountname) IN [$value, $value + '$']" +
+		" OR n.name STARTS WITH $value + '@' OR (n:Computer AND n.name STARTS WITH $value + '.'))" +
+		" AND (toUpper(n.domain) = $domain OR toUpper(n.domain) STARTS WITH $domain + '.') RETURN n.objectid"
+	if diff := cmp.Diff(want, stmt); diff != "" {
+		t.Errorf("resolveStatement() mismatch (-want got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"value": "SVC_SQL", "domain": "TESTLAB"}, params); diff != "" {
+		t.Errorf("resolveStatement() params mismatch (-want got):\n%s", diff)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	// #nosec G505
 	"crypto/sha1"
 	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -12,6 +13,39 @@ type cypher struct {
 	list      []map[string]interface{}
 }
 
+var (
+	relTypeRegex  = regexp.MustCompile(`\[r:(\w+)`)
+	nodeTypeRegex = regexp.MustCompile(`SET n:(\w+) SET`)
+)
+
+// statementType returns relationship type or node label created by statement
+// it is used to add context to logs
+func statementType(statement string) string {
+	if m := relTypeRegex.FindStringSubmatch(statement); m != nil {
+		return m[1]
+	}
+	if m := nodeTypeRegex.FindStringSubmatch(statement); m != nil {
+		return m[1]
+	}
+	return "unknown"
+}
+
+// objectIDs returns object ids of all the nodes in the list,
+// for relationships source object id is used
+func (c *cypher) objectIDs() []string {
+	ids := make([]string, 0, len(c.list))
+	for _, item := range c.list {
+		if id, ok := item["objectid"].(string); ok {
+			ids = append(ids, id)
+			continue
+		}
+		if id, ok := item["source"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 // used to create hash for cypher statement
 // bypassing gosec as sha1 is only used to generate unique key in map
 // #nosec G401
@@ -34,6 +68,44 @@ func buildACEStatement(sourceType, targetType, label string) string {
 		sourceType, targetType, label)
 }
 
+// buildInheritedACEStatement is used for inherited ACEs whose source container is known,
+// inheritedfrom is objectid of the container the ACE is inherited from
+func buildInheritedACEStatement(sourceType, targetType, label string) string {
+	return buildACEStatement(sourceType, targetType, label) + " SET r.inheritedfrom = item.inheritedfrom"
+}
+
+// buildDelegateStatement returns AllowedToDelegate statement, target isn't labelled
+// when its type is unknown so it gets its label once the target itself is uploaded
+func buildDelegateStatement(sourceType, targetType string) string {
+	target := "MERGE (m:Base {objectid: item.target})"
+	if targetType != "" {
+		target += " ON CREATE SET m:" + targetType
+	}
+	return fmt.Sprintf(`UNWIND $list AS item MERGE (n:Base {objectid: item.source}) ON CREATE SET n:%s %s MERGE (n)-[r:AllowedToDelegate {isacl:false}]->(m) SET r.protocoltransition = item.protocoltransition, r.spns = coalesce(item.spns, r.spns)`,
+		sourceType, target)
+}
+
+// addDelegateCyphers adds constrained delegation edges, protocolTransition marks accounts
+// which can delegate without Kerberos authentication of the user (trustedtoauth)
+func addDelegateCyphers(cyphers map[string]*cypher, targets []delegateTarget, identifier, idType string, protocolTransition bool) {
+	for _, t := range targets {
+		st := buildDelegateStatement(idType, t.ObjectType)
+		ht := hash(st)
+		if _, ok := cyphers[ht]; !ok {
+			cyphers[ht] = new(cypher)
+			cyphers[ht].statement = st
+		}
+		props := map[string]interface{}{"protocoltransition": protocolTransition}
+		if len(t.SPNs) > 0 {
+			props["spns"] = t.SPNs
+		}
+		item := edgeProperties("AllowedToDelegate", props)
+		item["source"] = identifier
+		item["target"] = t.ObjectIdentifier
+		cyphers[ht].list = append(cyphers[ht].list, item)
+	}
+}
+
 func addACECyphers(cyphers map[string]*cypher, aces []ace, identifier, idType string) {
 	for _, ace := range aces {
 		if identifier == ace.PrincipalSID {
@@ -83,6 +155,10 @@ func buildAECCyphers(cyphers map[string]*cypher, ace ace, identifier, idType, ac
 	}
 
 	st := buildACEStatement(ace.PrincipalType, idType, aceType)
+	if ace.IsInherited && ace.InheritedFrom != "" {
+		item["inheritedfrom"] = ace.InheritedFrom
+		st = buildInheritedACEStatement(ace.PrincipalType, idType, aceType)
+	}
 	ht := hash(st)
 	if _, ok := cyphers[ht]; !ok {
 		cyphers[ht] = new(cypher)
@@ -104,7 +180,7 @@ func buildUserCyphers(users []user) map[string]*cypher {
 			cyphers[ht] = new(cypher)
 			cyphers[ht].statement = st
 		}
-		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": u.Properties})
+		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": nodeProperties("User", u.Properties)})
 
 		// create ACEs transactions
 		addACECyphers(cyphers, u.Aces, identifier, "User")
@@ -119,17 +195,7 @@ func buildUserCyphers(users []user) map[string]*cypher {
 		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"source": identifier, "target": u.PrimaryGroupSid})
 
 		// Build allowedToDelegate Cypher
-		if len(u.AllowedToDelegate) > 0 {
-			st = buildRelStatement("User", "Computer", "AllowedToDelegate", "{isacl:false}")
-			ht = hash(st)
-			if _, ok := cyphers[ht]; !ok {
-				cyphers[ht] = new(cypher)
-				cyphers[ht].statement = st
-			}
-			for _, delegate := range u.AllowedToDelegate {
-				cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"source": identifier, "target": delegate})
-			}
-		}
+		addDelegateCyphers(cyphers, u.AllowedToDelegate, identifier, "User", trustedToAuth(u.Properties))
 
 		// Build HasSIDHistory Cypher
 		for _, m := range u.HasSIDHistory {
@@ -174,7 +240,7 @@ func buildComputerCyphers(computers []computer) map[string]*cypher {
 			cyphers[ht] = new(cypher)
 			cyphers[ht].statement = st
 		}
-		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": o.Properties})
+		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": nodeProperties("Computer", o.Properties)})
 
 		// create ACEs transactions
 		addACECyphers(cyphers, o.Aces, identifier, "Computer")
@@ -189,17 +255,7 @@ func buildComputerCyphers(computers []computer) map[string]*cypher {
 		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"source": identifier, "target": o.PrimaryGroupSid})
 
 		// Build allowedToDelegate Cypher
-		// if len(o.AllowedToDelegate) > 0 {
-		st = buildRelStatement("Computer", "Computer", "AllowedToDelegate", "{isacl:false}")
-		ht = hash(st)
-		if _, ok := cyphers[ht]; !ok {
-			cyphers[ht] = new(cypher)
-			cyphers[ht].statement = st
-		}
-		for _, delegate := range o.AllowedToDelegate {
-			cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"source": identifier, "target": delegate})
-		}
-		// }
+		addDelegateCyphers(cyphers, o.AllowedToDelegate, identifier, "Computer", trustedToAuth(o.Properties))
 
 		// check for AllowedToAct
 		for _, act := range o.AllowedToAct {
@@ -213,14 +269,27 @@ func buildComputerCyphers(computers []computer) map[string]*cypher {
 		}
 
 		// check for HasSession
-		for _, s := range o.Sessions {
-			st = buildRelStatement("Computer", "User", "HasSession", "{isacl:false}")
-			ht = hash(st)
-			if _, ok := cyphers[ht]; !ok {
-				cyphers[ht] = new(cypher)
-				cyphers[ht].statement = st
+		for _, sessions := range []struct {
+			method string
+			list   sessionList
+		}{
+			{sessionSourceNetSessionEnum, o.Sessions},
+			{sessionSourceLoggedOn, o.PrivilegedSessions},
+			{sessionSourceRegistry, o.RegistrySessions},
+		} {
+			for _, s := range sessions.list {
+				st = buildSessionStatement()
+				ht = hash(st)
+				if _, ok := cyphers[ht]; !ok {
+					cyphers[ht] = new(cypher)
+					cyphers[ht].statement = st
+				}
+				method := sessions.method
+				if s.Legacy && method == sessionSourceNetSessionEnum {
+					method = sessionSourceUnknown
+				}
+				cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"source": s.ComputerID, "target": s.UserID, "method": method})
 			}
-			cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"source": s.ComputerID, "target": s.UserID})
 		}
 
 		// check for localAdmins
@@ -286,7 +355,7 @@ func buildGroupCyphers(groups []group) map[string]*cypher {
 			cyphers[ht] = new(cypher)
 			cyphers[ht].statement = st
 		}
-		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": o.Properties})
+		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": nodeProperties("Group", o.Properties)})
 
 		// create ACEs transactions
 		addACECyphers(cyphers, o.Aces, identifier, "Group")
@@ -320,7 +389,7 @@ func buildGPOCyphers(gpos []gpo) map[string]*cypher {
 			cyphers[ht] = new(cypher)
 			cyphers[ht].statement = st
 		}
-		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": o.Properties})
+		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": nodeProperties("GPO", o.Properties)})
 
 		// create ACEs transactions
 		addACECyphers(cyphers, o.Aces, identifier, "GPO")
@@ -329,6 +398,17 @@ func buildGPOCyphers(gpos []gpo) map[string]*cypher {
 	return cyphers
 }
 
+// ouProperties returns properties of the OU with 'aclprotected' set from ACLProtected,
+// o.Properties isn't modified
+func ouProperties(o ou) map[string]interface{} {
+	props := make(map[string]interface{}, len(o.Properties)+1)
+	for k, v := range o.Properties {
+		props[k] = v
+	}
+	props["aclprotected"] = o.ACLProtected
+	return props
+}
+
 func buildOUCyphers(ous []ou) map[string]*cypher {
 	cyphers := make(map[string]*cypher)
 
@@ -342,7 +422,7 @@ func buildOUCyphers(ous []ou) map[string]*cypher {
 			cyphers[ht] = new(cypher)
 			cyphers[ht].statement = st
 		}
-		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": o.Properties})
+		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": nodeProperties("OU", ouProperties(o))})
 
 		// create ACEs transactions
 		addACECyphers(cyphers, o.Aces, identifier, "OU")
@@ -454,6 +534,98 @@ func buildOUCyphers(ous []ou) map[string]*cypher {
 	return cyphers
 }
 
+// buildTrustStatement returns statement of TrustedBy or DisabledTrust edge. edges are merged on their type only
+// and trust properties are set so re-imported trusts are updated rather than duplicated
+func buildTrustStatement(edgeType string) string {
+	return buildRelStatement("Domain", "Domain", edgeType, "{isacl: false}") +
+		" SET r.sidfiltering = item.sidfiltering, r.trusttype = item.trusttype, r.transitive = item.transitive," +
+		" r.trusttypevalue = item.trusttypevalue, r.trustdirection = item.trustdirection, r.trustattributes = item.trustattributes," +
+		" r.withinforest = item.withinforest, r.sidhistoryabusable = item.sidhistoryabusable"
+}
+
+// staleTrustsStatement deletes TrustedBy and DisabledTrust edges between the domain and the target
+// of the trust in either direction which aren't among item.edges ('type:source objectid') of the trust,
+// so trusts which changed direction or were enabled or disabled since previous import don't leave stale edges.
+// items don't have source and target as the statement doesn't create edges
+const staleTrustsStatement = `UNWIND $list AS item MATCH (n:Domain {objectid: item.domain})-[r:TrustedBy|DisabledTrust]-(m:Domain {objectid: item.trusted}) ` +
+	`WHERE NOT type(r) + ':' + startNode(r).objectid IN item.edges DELETE r`
+
+// addTrustCyphers adds target domain node and trust edges. TrustedBy edges go from the trusting to the
+// trusted domain, disabled trusts are recorded as DisabledTrust edge from the domain to the target
+// so they aren't used by path finding.
+// TrustDirection: Disabled = 0, Inbound = 1, Outbound = 2, Bidirectional = 3
+// TrustType: ParentChild = 0, CrossLink = 1, Forest = 2, External = 3, Unknown = 4
+func addTrustCyphers(cyphers map[string]*cypher, trust trust, identifier string) {
+	// trusted domains which can't be resolved don't have SID
+	target := trust.TargetDomainSid
+	if target == "" {
+		target = strings.ToUpper(trust.TargetDomainName)
+	}
+
+	// create node for target domain
+	st := buildNodeStatement("Domain")
+	ht := hash(st)
+	if _, ok := cyphers[ht]; !ok {
+		cyphers[ht] = new(cypher)
+		cyphers[ht].statement = st
+	}
+	targetProps := map[string]interface{}{"name": trust.TargetDomainName}
+	if trust.TargetDomainSid != "" {
+		targetProps["objectid"] = trust.TargetDomainSid
+		targetProps["domainsid"] = trust.TargetDomainSid
+	}
+	cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": target, "properties": targetProps})
+
+	edgeType := "TrustedBy"
+	if trust.TrustDirection == 0 {
+		edgeType = "DisabledTrust"
+	}
+	props := edgeProperties(edgeType, map[string]interface{}{
+		"trusttype":          trustTypeName(trust.TrustType),
+		"trusttypevalue":     trust.TrustType,
+		"trustdirection":     trustDirectionName(trust.TrustDirection),
+		"trustattributes":    trust.TrustAttributes,
+		"transitive":         trust.IsTransitive,
+		"sidfiltering":       trust.SidFilteringEnabled,
+		"withinforest":       trust.withinForest(),
+		"sidhistoryabusable": trust.sidHistoryAbusable(),
+	})
+	item := func(source, target string) map[string]interface{} {
+		item := map[string]interface{}{"source": source, "target": target}
+		for k, v := range props {
+			item[k] = v
+		}
+		return item
+	}
+	st = buildTrustStatement(edgeType)
+	ht = hash(st)
+	if _, ok := cyphers[ht]; !ok {
+		cyphers[ht] = new(cypher)
+		cyphers[ht].statement = st
+	}
+	var items []map[string]interface{}
+	switch trust.TrustDirection {
+	case 0, 1:
+		items = append(items, item(identifier, target))
+	case 2:
+		items = append(items, item(target, identifier))
+	case 3:
+		items = append(items, item(identifier, target), item(target, identifier))
+	}
+	cyphers[ht].list = append(cyphers[ht].list, items...)
+
+	edges := make([]string, 0, len(items))
+	for _, i := range items {
+		edges = append(edges, edgeType+":"+i["source"].(string))
+	}
+	ht = hash(staleTrustsStatement)
+	if _, ok := cyphers[ht]; !ok {
+		cyphers[ht] = new(cypher)
+		cyphers[ht].statement = staleTrustsStatement
+	}
+	cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"domain": identifier, "trusted": target, "edges": edges})
+}
+
 func buildDomainCyphers(domains []domain) map[string]*cypher {
 	cyphers := make(map[string]*cypher)
 
@@ -467,7 +639,7 @@ func buildDomainCyphers(domains []domain) map[string]*cypher {
 			cyphers[ht] = new(cypher)
 			cyphers[ht].statement = st
 		}
-		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": o.Properties})
+		cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": identifier, "properties": nodeProperties("Domain", o.Properties)})
 
 		// create ACEs transactions
 		addACECyphers(cyphers, o.Aces, identifier, "Domain")
@@ -575,75 +747,8 @@ func buildDomainCyphers(domains []domain) map[string]*cypher {
 		}
 
 		// Domain Trust
-		/*
-			        TrustDirection
-			        Disabled = 0
-			        Inbound = 1,
-			        Outbound = 2,
-			        Bidirectional = 3
-
-			        TrustType
-			        ParentChild = 0,
-			        CrossLink = 1,
-			        Forest = 2,
-			        External = 3,
-					Unknown = 4
-		*/
 		for _, trust := range o.Trusts {
-			var trustType string
-			switch trust.TrustType {
-			case 0:
-				trustType = "ParentChild"
-			case 1:
-				trustType = "CrossLink"
-			case 2:
-				trustType = "Forest"
-			case 3:
-				trustType = "External"
-			case 4:
-				trustType = "Unknown"
-			default:
-				continue
-			}
-
-			target := trust.TargetDomainSid
-			targetName := trust.TargetDomainName
-
-			// create node for target domain
-			st := buildNodeStatement("Domain")
-			ht := hash(st)
-			if _, ok := cyphers[ht]; !ok {
-				cyphers[ht] = new(cypher)
-				cyphers[ht].statement = st
-			}
-			cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{"objectid": target, "properties": map[string]interface{}{"name": targetName}})
-
-			st = buildRelStatement("Domain", "Domain", "TrustedBy", "{sidfiltering: item.sidfiltering, trusttype: item.trusttype, transitive: item.transitive, isacl: false}")
-			ht = hash(st)
-			if _, ok := cyphers[ht]; !ok {
-				cyphers[ht] = new(cypher)
-				cyphers[ht].statement = st
-			}
-			if trust.TrustDirection == 1 || trust.TrustDirection == 3 {
-				cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{
-					"source":       identifier,
-					"target":       target,
-					"trusttype":    trustType,
-					"transitive":   trust.IsTransitive,
-					"sidfiltering": trust.SidFilteringEnabled,
-				})
-
-			}
-
-			if trust.TrustDirection == 2 || trust.TrustDirection == 3 {
-				cyphers[ht].list = append(cyphers[ht].list, map[string]interface{}{
-					"source":       target,
-					"target":       identifier,
-					"trusttype":    trustType,
-					"transitive":   trust.IsTransitive,
-					"sidfiltering": trust.SidFilteringEnabled,
-				})
-			}
+			addTrustCyphers(cyphers, trust, identifier)
 		}
 	}
 	return cyphers
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const redactedValue = "REDACTED"
+
+// properties which are never removed as nodes can't be identified without them
+var requiredProperties = map[string]bool{"objectid": true, "name": true}
+
+// relationship types with properties set from the data, properties of custom
+// relationships are only matched by rules without label
+var policyRelationships = map[string]string{
+	"allowedtodelegate": "AllowedToDelegate",
+	"trustedby":         "TrustedBy",
+	"disabledtrust":     "DisabledTrust",
+}
+
+// propertyRule matches properties of nodes with the label or relationships of the type,
+// empty label matches all nodes and relationships
+type propertyRule struct {
+	label   string
+	pattern string
+}
+
+func (r propertyRule) match(label, key string) bool {
+	if r.label != "" && r.label != label {
+		return false
+	}
+	ok, _ := path.Match(r.pattern, key)
+	return ok
+}
+
+// propertyPolicy filters and anonymises node and relationship properties before upload
+type propertyPolicy struct {
+	allow  []propertyRule
+	deny   []propertyRule
+	redact []propertyRule
+	hash   []propertyRule
+	salt   []byte
+}
+
+// policy is applied to properties of all nodes and relationships, it's set from '--bhi-property-*' flags
+var policy = &propertyPolicy{}
+
+// parsePropertyRules parses rules in 'Label:pattern' or 'pattern' form, label is node label
+// or relationship type and pattern is glob matched against lowercase property key
+// e.g. 'User:email', 'AllowedToDelegate:spns' or 'ms-*'
+func parsePropertyRules(values []string) ([]propertyRule, error) {
+	var rules []propertyRule
+	for _, v := range values {
+		r := propertyRule{pattern: v}
+		if i := strings.Index(v, ":"); i >= 0 {
+			label, ok := knownLabels[strings.ToLower(v[:i])]
+			if !ok {
+				label, ok = policyRelationships[strings.ToLower(v[:i])]
+			}
+			if !ok && v[:i] != "*" {
+				return nil, fmt.Errorf("invalid property rule %q, unknown label or relationship type %s", v, v[:i])
+			}
+			r.label, r.pattern = label, v[i+1:]
+		}
+		r.pattern = strings.ToLower(r.pattern)
+		if _, err := path.Match(r.pattern, ""); err != nil || r.pattern == "" {
+			return nil, fmt.Errorf("invalid property rule %q, invalid pattern", v)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func newPropertyPolicy(allow, deny, redact, hash []string, salt string) (*propertyPolicy, error) {
+	p := &propertyPolicy{salt: []byte(salt)}
+	var err error
+	if p.allow, err = parsePropertyRules(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parsePropertyRules(deny); err != nil {
+		return nil, err
+	}
+	if p.redact, err = parsePropertyRules(redact); err != nil {
+		return nil, err
+	}
+	if p.hash, err = parsePropertyRules(hash); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func matchRules(rules []propertyRule, label, key string) bool {
+	for _, r := range rules {
+		if r.match(label, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAllowRules returns whether allow list is set for the label
+func (p *propertyPolicy) hasAllowRules(label string) bool {
+	for _, r := range p.allow {
+		if r.label == "" || r.label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// apply removes properties which aren't allowed or are denied and replaces values
+// of redacted and hashed properties. props is modified in place
+func (p *propertyPolicy) apply(label string, props map[string]interface{}) map[string]interface{} {
+	allowList := p.hasAllowRules(label)
+	for k, v := range props {
+		if requiredProperties[k] {
+			continue
+		}
+		switch {
+		case allowList && !matchRules(p.allow, label, k), matchRules(p.deny, label, k):
+			delete(props, k)
+		case matchRules(p.redact, label, k):
+			props[k] = redactedValue
+		case matchRules(p.hash, label, k):
+			props[k] = p.hashValue(v)
+		}
+	}
+	return props
+}
+
+// edgeProperties returns copy of properties of the relationship with property policy applied
+func edgeProperties(edgeType string, props map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		c[k] = v
+	}
+	return policy.apply(edgeType, c)
+}
+
+// hashValue returns hex encoded sha256 of the value, HMAC-SHA256 with salt
+// as the key is used when salt is set. elements of lists are hashed separately
+func (p *propertyPolicy) hashValue(v interface{}) interface{} {
+	switch l := v.(type) {
+	case []interface{}:
+		hashed := make([]interface{}, len(l))
+		for i, e := range l {
+			hashed[i] = p.hashValue(e)
+		}
+		return hashed
+	case []string:
+		hashed := make([]string, len(l))
+		for i, e := range l {
+			hashed[i] = p.hashValue(e).(string)
+		}
+		return hashed
+	}
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	if len(p.salt) == 0 {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parsePropertyRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []propertyRule
+		wantErr bool
+	}{
+		{
+			name:   "labels and patterns",
+			values: []string{"User:Email", "ms-*", "*:description", "ou:gpoptions", "allowedtodelegate:SPNs"},
+			want: []propertyRule{
+				{label: "User", pattern: "email"},
+				{pattern: "ms-*"},
+				{pattern: "description"},
+				{label: "OU", pattern: "gpoptions"},
+				{label: "AllowedToDelegate", pattern: "spns"},
+			},
+		},
+		{
+			name:    "unknown label",
+			values:  []string{"Printer:name"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid pattern",
+			values:  []string{"User:[email"},
+			wantErr: true,
+		},
+		{
+			name:    "empty pattern",
+			values:  []string{"User:"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePropertyRules(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePropertyRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(propertyRule{})); diff != "" {
+				t.Errorf("parsePropertyRules() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_propertyPolicy_apply(t *testing.T) {
+	props := func() map[string]interface{} {
+		return map[string]interface{}{
+			"objectid":              "S-1-5-21-1-1105",
+			"name":                  "USER@TESTLAB.LOCAL",
+			"email":                 "user@testlab.local",
+			"description":           "password is Summer2020",
+			"serviceprincipalnames": []string{"HTTP/web"},
+			"enabled":               true,
+		}
+	}
+	tests := []struct {
+		name                        string
+		allow, deny, redact, hashes []string
+		salt                        string
+		label                       string
+		want                        map[string]interface{}
+	}{
+		{
+			name:  "empty policy",
+			label: "User",
+			want:  props(),
+		},
+		{
+			name:  "allow list keeps required properties",
+			allow: []string{"User:enabled"},
+			label: "User",
+			want: map[string]interface{}{
+				"objectid": "S-1-5-21-1-1105",
+				"name":     "USER@TESTLAB.LOCAL",
+				"enabled":  true,
+			},
+		},
+		{
+			name:  "allow list of other label",
+			allow: []string{"Computer:enabled"},
+			label: "User",
+			want:  props(),
+		},
+		{
+			name:   "deny, redact and hash",
+			deny:   []string{"email", "name"},
+			redact: []string{"User:desc*"},
+			hashes: []string{"serviceprincipalnames"},
+			label:  "User",
+			want: map[string]interface{}{
+				"objectid":              "S-1-5-21-1-1105",
+				"name":                  "USER@TESTLAB.LOCAL",
+				"description":           "REDACTED",
+				"serviceprincipalnames": []string{"e89c1e3554bf8fbd5973bf91a3f83d15f86d200c861662025224b0a836270f99"},
+				"enabled":               true,
+			},
+		},
+		{
+			name:   "salted hash",
+			hashes: []string{"email"},
+			salt:   "secret",
+			label:  "User",
+			want: map[string]interface{}{
+				"objectid":              "S-1-5-21-1-1105",
+				"name":                  "USER@TESTLAB.LOCAL",
+				"email":                 "6fe53130200b311e9800edb5922c8a475471abd0b1a3c5ebedd744057b77a411",
+				"description":           "password is Summer2020",
+				"serviceprincipalnames": []string{"HTTP/web"},
+				"enabled":               true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPropertyPolicy(tt.allow, tt.deny, tt.redact, tt.hashes, tt.salt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := p.apply(tt.label, props())
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("apply() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_edgeProperties(t *testing.T) {
+	p, err := newPropertyPolicy(nil, []string{"AllowedToDelegate:spns", "TrustedBy:sidfiltering"}, []string{"secret"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p *propertyPolicy) { policy = p }(policy)
+	policy = p
+
+	got := make(map[string]*cypher)
+	addDelegateCyphers(got, []delegateTarget{{ObjectIdentifier: "S-1-5-21-1-1002", ObjectType: "Computer", SPNs: []string{"cifs/files"}}}, "S-1-5-21-1-500", "User", true)
+	addTrustCyphers(got, trust{TargetDomainSid: "S-1-5-21-2", TargetDomainName: "EXTERNAL.LOCAL", TrustDirection: 1, SidFilteringEnabled: true}, "S-1-5-21-1")
+	props := map[string]interface{}{"secret": "hunter2", "note": "kept"}
+	for h, c := range buildCustomEdgeCyphers([]customEdge{{Type: "HasSecret", Source: customEndpoint{ObjectID: "S-1-5-21-1-500"}, Target: customEndpoint{ObjectID: "S-1-5-21-1-1002"}, Properties: props}}) {
+		got[h] = c
+	}
+
+	delegate := got[hash(buildDelegateStatement("User", "Computer"))].list[0]
+	if diff := cmp.Diff(map[string]interface{}{"source": "S-1-5-21-1-500", "target": "S-1-5-21-1-1002", "protocoltransition": true}, delegate); diff != "" {
+		t.Errorf("delegation item mismatch (-want got):\n%s", diff)
+	}
+	trusted := got[hash(buildTrustStatement("TrustedBy"))].list[0]
+	if _, ok := trusted["sidfiltering"]; ok || trusted["trustdirection"] == nil {
+		t.Errorf("trust item = %v", trusted)
+	}
+	custom := got[hash(buildCustomEdgeStatement("HasSecret", customEndpoint{ObjectID: "x"}, customEndpoint{ObjectID: "y"}))].list[0]
+	if diff := cmp.Diff(map[string]interface{}{"secret": redactedValue, "note": "kept"}, custom["properties"]); diff != "" {
+		t.Errorf("custom edge properties mismatch (-want got):\n%s", diff)
+	}
+	if props["secret"] != "hunter2" {
+		t.Error("properties of custom edge are modified")
+	}
+}
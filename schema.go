@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// propertyType is expected type of node property
+type propertyType int
+
+const (
+	propertyString propertyType = iota
+	propertyBool
+	propertyInt
+	// propertyTimestamp is unix time in seconds, 0 and -1 mean not set and never
+	propertyTimestamp
+	propertyStringList
+)
+
+func (t propertyType) String() string {
+	switch t {
+	case propertyBool:
+		return "bool"
+	case propertyInt:
+		return "int"
+	case propertyTimestamp:
+		return "timestamp"
+	case propertyStringList:
+		return "string list"
+	}
+	return "string"
+}
+
+// timestamp formats of node properties
+const (
+	timestampEpoch    = "epoch"
+	timestampDatetime = "datetime"
+)
+
+// timestampFormat is format timestamps are written in, 'epoch' (integer unix time)
+// or 'datetime' (neo4j DateTime), it's set from '--bhi-timestamp-format' flag
+var timestampFormat = timestampEpoch
+
+func setTimestampFormat(format string) error {
+	switch format {
+	case timestampEpoch, timestampDatetime:
+		timestampFormat = format
+		return nil
+	}
+	return fmt.Errorf("unsupported timestamp format %q", format)
+}
+
+// currentTime returns cypher expression of the current time in timestamp format
+func currentTime() string {
+	if timestampFormat == timestampDatetime {
+		return "datetime()"
+	}
+	return "timestamp() / 1000"
+}
+
+// commonSchema are properties shared by all node labels
+var commonSchema = map[string]propertyType{
+	"objectid":          propertyString,
+	"name":              propertyString,
+	"domain":            propertyString,
+	"distinguishedname": propertyString,
+	"description":       propertyString,
+	"highvalue":         propertyBool,
+	"whencreated":       propertyTimestamp,
+}
+
+// propertySchemas are properties of node labels set by SharpHound and supported collectors,
+// properties which aren't in the schema are written as they are
+var propertySchemas = map[string]map[string]propertyType{
+	"User": {
+		"samaccountname":          propertyString,
+		"displayname":             propertyString,
+		"email":                   propertyString,
+		"title":                   propertyString,
+		"homedirectory":           propertyString,
+		"userpassword":            propertyString,
+		"admincount":              propertyBool,
+		"enabled":                 propertyBool,
+		"hasspn":                  propertyBool,
+		"dontreqpreauth":          propertyBool,
+		"passwordnotreqd":         propertyBool,
+		"pwdneverexpires":         propertyBool,
+		"sensitive":               propertyBool,
+		"trustedtoauth":           propertyBool,
+		"unconstraineddelegation": propertyBool,
+		"useraccountcontrol":      propertyInt,
+		"lastlogon":               propertyTimestamp,
+		"lastlogontimestamp":      propertyTimestamp,
+		"pwdlastset":              propertyTimestamp,
+		"serviceprincipalnames":   propertyStringList,
+		"sidhistory":              propertyStringList,
+		"allowedtodelegate":       propertyStringList,
+	},
+	"Computer": {
+		"samaccountname":          propertyString,
+		"operatingsystem":         propertyString,
+		"admincount":              propertyBool,
+		"enabled":                 propertyBool,
+		"haslaps":                 propertyBool,
+		"hasspn":                  propertyBool,
+		"trustedtoauth":           propertyBool,
+		"unconstraineddelegation": propertyBool,
+		"useraccountcontrol":      propertyInt,
+		"lastlogon":               propertyTimestamp,
+		"lastlogontimestamp":      propertyTimestamp,
+		"pwdlastset":              propertyTimestamp,
+		"serviceprincipalnames":   propertyStringList,
+		"sidhistory":              propertyStringList,
+		"allowedtodelegate":       propertyStringList,
+	},
+	"Group": {
+		"admincount": propertyBool,
+	},
+	"GPO": {
+		"gpcpath": propertyString,
+	},
+	"OU": {
+		"blocksinheritance": propertyBool,
+		"aclprotected":      propertyBool,
+	},
+	"Domain": {
+		"functionallevel": propertyString,
+	},
+}
+
+// unexpectedTypes records reported unexpected property types so each is logged once
+var unexpectedTypes sync.Map
+
+// nodeProperties returns properties of the node with keys lowercased, nil values
+// dropped, flags derived from useraccountcontrol, values coerced to the type
+// of the label's schema and property policy applied. props isn't modified
+func nodeProperties(label string, props map[string]interface{}) map[string]interface{} {
+	props = normaliseProperties(props)
+	for k, v := range props {
+		if v == nil {
+			delete(props, k)
+		}
+	}
+
+	switch label {
+	case "User":
+		props = deriveUACProperties(props, userUACProperties)
+	case "Computer":
+		props = deriveUACProperties(props, computerUACProperties)
+	}
+
+	for k, v := range props {
+		t, ok := propertySchemas[label][k]
+		if !ok {
+			t, ok = commonSchema[k]
+		}
+		if !ok {
+			continue
+		}
+		coerced, ok := coerceProperty(v, t)
+		if !ok {
+			reportUnexpectedType(label, k, v, t)
+			continue
+		}
+		if coerced == nil {
+			delete(props, k)
+			continue
+		}
+		props[k] = coerced
+	}
+	return policy.apply(label, props)
+}
+
+// coerceProperty converts value to the type, false is returned if value can't be converted.
+// timestamps may be coerced to nil which means property is dropped
+func coerceProperty(v interface{}, t propertyType) (interface{}, bool) {
+	switch t {
+	case propertyString:
+		s, ok := v.(string)
+		return s, ok
+	case propertyBool:
+		switch b := v.(type) {
+		case bool:
+			return b, true
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(b))
+			return parsed, err == nil
+		}
+		return nil, false
+	case propertyInt:
+		return toInt64(v)
+	case propertyTimestamp:
+		i, ok := toTimestamp(v)
+		if !ok {
+			return nil, false
+		}
+		if timestampFormat != timestampDatetime {
+			return i, true
+		}
+		// not set and never don't have datetime representation
+		if i <= 0 {
+			return nil, true
+		}
+		return time.Unix(i, 0).UTC(), true
+	case propertyStringList:
+		switch l := v.(type) {
+		case []string:
+			return l, true
+		case []interface{}:
+			for _, e := range l {
+				if _, ok := e.(string); !ok {
+					return nil, false
+				}
+			}
+			return l, true
+		case string:
+			return []string{l}, true
+		}
+		return nil, false
+	}
+	return v, true
+}
+
+// toInt64 converts integral numbers and numeric strings to int64
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		if n != math.Trunc(n) || math.IsInf(n, 0) {
+			return 0, false
+		}
+		return int64(n), true
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, true
+		}
+		return toInt64Float(n.String())
+	case string:
+		if i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64); err == nil {
+			return i, true
+		}
+		return toInt64Float(n)
+	}
+	return 0, false
+}
+
+// toTimestamp converts epoch timestamp to int64, fractional seconds written by some collectors are truncated
+func toTimestamp(v interface{}) (int64, bool) {
+	if i, ok := toInt64(v); ok {
+		return i, true
+	}
+	var f float64
+	var err error
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case json.Number:
+		f, err = n.Float64()
+	case string:
+		f, err = strconv.ParseFloat(strings.TrimSpace(n), 64)
+	default:
+		return 0, false
+	}
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, false
+	}
+	return int64(math.Trunc(f)), true
+}
+
+func toInt64Float(s string) (int64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false
+	}
+	return toInt64(f)
+}
+
+func reportUnexpectedType(label, key string, v interface{}, t propertyType) {
+	id := fmt.Sprintf("%s.%s.%T", label, key, v)
+	if _, reported := unexpectedTypes.LoadOrStore(id, true); reported {
+		return
+	}
+	log.WithFields(map[string]interface{}{
+		"label":    label,
+		"property": key,
+		"type":     fmt.Sprintf("%T", v),
+	}).Warnf("unexpected property type, expected %s, value is written as it is", t)
+}
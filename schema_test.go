@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_nodeProperties(t *testing.T) {
+	tests := []struct {
+		name   string
+		label  string
+		format string
+		props  map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{
+			name:  "nil properties",
+			label: "Group",
+			props: nil,
+			want:  nil,
+		},
+		{
+			name:  "timestamps, nils and keys",
+			label: "Computer",
+			props: map[string]interface{}{
+				"Name":               "SRV01.TESTLAB.LOCAL",
+				"description":        nil,
+				"lastlogon":          1.583951963e+09,
+				"pwdlastset":         json.Number("1583951963.52"),
+				"lastlogontimestamp": "-1",
+				"whencreated":        float64(0),
+				"haslaps":            "True",
+				"custom":             1.5,
+			},
+			want: map[string]interface{}{
+				"name":               "SRV01.TESTLAB.LOCAL",
+				"lastlogon":          int64(1583951963),
+				"pwdlastset":         int64(1583951963),
+				"lastlogontimestamp": int64(-1),
+				"whencreated":        int64(0),
+				"haslaps":            true,
+				"custom":             1.5,
+			},
+		},
+		{
+			name:   "datetime timestamps",
+			label:  "User",
+			format: timestampDatetime,
+			props: map[string]interface{}{
+				"lastlogon":   1.583951963e+09,
+				"pwdlastset":  float64(0),
+				"whencreated": 1583951963.75,
+			},
+			want: map[string]interface{}{
+				"lastlogon":   time.Unix(1583951963, 0).UTC(),
+				"whencreated": time.Unix(1583951963, 0).UTC(),
+			},
+		},
+		{
+			name:  "unexpected types are kept",
+			label: "User",
+			props: map[string]interface{}{
+				"lastlogon":             "yesterday",
+				"serviceprincipalnames": []interface{}{"HTTP/web", 1.0},
+				"admincount":            1.0,
+			},
+			want: map[string]interface{}{
+				"lastlogon":             "yesterday",
+				"serviceprincipalnames": []interface{}{"HTTP/web", 1.0},
+				"admincount":            1.0,
+			},
+		},
+		{
+			name:  "useraccountcontrol",
+			label: "Computer",
+			props: map[string]interface{}{"useraccountcontrol": 4096.0},
+			want: map[string]interface{}{
+				"useraccountcontrol":      int64(4096),
+				"enabled":                 true,
+				"unconstraineddelegation": false,
+				"trustedtoauth":           false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.format != "" {
+				timestampFormat = tt.format
+				defer func() { timestampFormat = timestampEpoch }()
+			}
+			got := nodeProperties(tt.label, tt.props)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("nodeProperties() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
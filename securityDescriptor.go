@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// security descriptor control flags
+const sdControlDACLProtected = 0x1000
+
+// ACE types and flags
+// https://docs.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-ace_header
+const (
+	aceTypeAccessAllowed       = 0x00
+	aceTypeAccessAllowedObject = 0x05
+
+	aceFlagObjectInherit    = 0x01
+	aceFlagContainerInherit = 0x02
+	aceFlagInheritOnly      = 0x08
+	aceFlagInherited        = 0x10
+
+	aceObjectTypePresent          = 0x1
+	aceInheritedObjectTypePresent = 0x2
+)
+
+// access mask bits of directory objects
+// https://docs.microsoft.com/en-us/windows/win32/api/iads/ne-iads-ads_rights_enum
+const (
+	rightSelf           = 0x8
+	rightWriteProperty  = 0x20
+	rightExtended       = 0x100
+	rightWriteDacl      = 0x40000
+	rightWriteOwner     = 0x80000
+	rightGenericRead    = 0x80000000
+	rightGenericWrite   = 0x40000000
+	rightGenericExecute = 0x20000000
+	rightGenericAll     = 0x10000000
+	// GenericAll as stored in security descriptors, generic rights are mapped to standard and object specific rights
+	rightFullControl = 0xF01FF
+)
+
+// genericRightsMapping maps generic rights to standard and object specific rights of directory objects,
+// inherited ACEs are stored with mapped rights
+// https://docs.microsoft.com/en-us/windows/win32/adschema/access-rights
+var genericRightsMapping = map[uint32]uint32{
+	rightGenericRead:    0x20094,
+	rightGenericWrite:   0x20028,
+	rightGenericExecute: 0x20004,
+	rightGenericAll:     rightFullControl,
+}
+
+// object type GUIDs of extended rights and properties which are imported as edges
+const (
+	guidGetChanges           = "1131F6AA-9C07-11D1-F79F-00C04FC2DCD2"
+	guidGetChangesAll        = "1131F6AD-9C07-11D1-F79F-00C04FC2DCD2"
+	guidForceChangePassword  = "00299570-246D-11D0-A768-00AA006E0529"
+	guidMember               = "BF9679C0-0DE6-11D0-A285-00AA003049E2"
+	guidAllowedToActOnBehalf = "3F78C3E5-F79A-46BD-A0B8-9D18116DDC79"
+)
+
+// schemaIDGUIDs of object classes, used to check if inherited ACE applies to the object
+var classGUIDs = map[string]string{
+	"user":                            "BF967ABA-0DE6-11D0-A285-00AA003049E2",
+	"computer":                        "BF967A86-0DE6-11D0-A285-00AA003049E2",
+	"group":                           "BF967A9C-0DE6-11D0-A285-00AA003049E2",
+	"domaindns":                       "19195A5A-6DA0-11D0-AFD3-00C04FD930C9",
+	"grouppolicycontainer":            "F30E3BC2-9FF0-11D1-B603-0000F80367C1",
+	"organizationalunit":              "BF967AA5-0DE6-11D0-A285-00AA003049E2",
+	"msds-groupmanagedserviceaccount": "7B8B558A-93A5-4AF7-ADCA-C017E67F1057",
+}
+
+// lapsAttributes are attributes holding LAPS passwords, their schemaIDGUID differs between forests
+var lapsAttributes = []string{"ms-mcs-admpwd", "mslaps-password", "mslaps-encryptedpassword"}
+
+type securityDescriptor struct {
+	control uint16
+	owner   string
+	dacl    []accessControlEntry
+}
+
+type accessControlEntry struct {
+	aceType             byte
+	flags               byte
+	mask                uint32
+	objectType          string
+	inheritedObjectType string
+	sid                 string
+}
+
+func (sd *securityDescriptor) daclProtected() bool {
+	return sd.control&sdControlDACLProtected != 0
+}
+
+// parseSecurityDescriptor parses self relative security descriptor as stored
+// in nTSecurityDescriptor attribute, SACL isn't parsed
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-dtyp/7d4dac05-9cef-4563-a058-f108abecce1d
+func parseSecurityDescriptor(b []byte) (*securityDescriptor, error) {
+	if len(b) < 20 {
+		return nil, fmt.Errorf("invalid security descriptor length %d", len(b))
+	}
+	sd := &securityDescriptor{control: binary.LittleEndian.Uint16(b[2:])}
+
+	if offset := binary.LittleEndian.Uint32(b[4:]); offset != 0 {
+		if int(offset) >= len(b) {
+			return nil, fmt.Errorf("invalid owner offset %d", offset)
+		}
+		owner, err := sidToString(b[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse owner %w", err)
+		}
+		sd.owner = owner
+	}
+
+	if offset := binary.LittleEndian.Uint32(b[16:]); offset != 0 {
+		if int(offset)+8 > len(b) {
+			return nil, fmt.Errorf("invalid DACL offset %d", offset)
+		}
+		dacl, err := parseACL(b[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse DACL %w", err)
+		}
+		sd.dacl = dacl
+	}
+	return sd, nil
+}
+
+func parseACL(b []byte) ([]accessControlEntry, error) {
+	size := int(binary.LittleEndian.Uint16(b[2:]))
+	count := int(binary.LittleEndian.Uint16(b[4:]))
+	if size < 8 || size > len(b) {
+		return nil, fmt.Errorf("invalid ACL size %d", size)
+	}
+	b = b[:size]
+
+	var aces []accessControlEntry
+	offset := 8
+	for i := 0; i < count; i++ {
+		if offset+4 > len(b) {
+			return nil, fmt.Errorf("ACE %d out of ACL bounds", i)
+		}
+		aceSize := int(binary.LittleEndian.Uint16(b[offset+2:]))
+		if aceSize < 4 || offset+aceSize > len(b) {
+			return nil, fmt.Errorf("invalid ACE %d size %d", i, aceSize)
+		}
+		a, ok, err := parseACE(b[offset : offset+aceSize])
+		if err != nil {
+			return nil, fmt.Errorf("ACE %d %w", i, err)
+		}
+		if ok {
+			aces = append(aces, a)
+		}
+		offset += aceSize
+	}
+	return aces, nil
+}
+
+// parseACE parses access allowed ACEs, other ACE types are skipped
+func parseACE(b []byte) (accessControlEntry, bool, error) {
+	a := accessControlEntry{aceType: b[0], flags: b[1]}
+	if a.aceType != aceTypeAccessAllowed && a.aceType != aceTypeAccessAllowedObject {
+		return a, false, nil
+	}
+	if len(b) < 8 {
+		return a, false, fmt.Errorf("invalid length %d", len(b))
+	}
+	a.mask = binary.LittleEndian.Uint32(b[4:])
+	body := b[8:]
+
+	if a.aceType == aceTypeAccessAllowedObject {
+		if len(body) < 4 {
+			return a, false, fmt.Errorf("invalid object ACE length %d", len(b))
+		}
+		flags := binary.LittleEndian.Uint32(body)
+		body = body[4:]
+		var err error
+		if flags&aceObjectTypePresent != 0 {
+			if a.objectType, err = guidToString(prefix(body, 16)); err != nil {
+				return a, false, err
+			}
+			body = body[16:]
+		}
+		if flags&aceInheritedObjectTypePresent != 0 {
+			if a.inheritedObjectType, err = guidToString(prefix(body, 16)); err != nil {
+				return a, false, err
+			}
+			body = body[16:]
+		}
+	}
+
+	sid, err := sidToString(body)
+	if err != nil {
+		return a, false, err
+	}
+	a.sid = sid
+	return a, true, nil
+}
+
+// prefix returns first n bytes of b or whole b if it's shorter
+func prefix(b []byte, n int) []byte {
+	if len(b) < n {
+		return b
+	}
+	return b[:n]
+}
+
+// mappedMask returns access mask with generic rights mapped to rights of directory objects
+func (a accessControlEntry) mappedMask() uint32 {
+	mask := a.mask
+	for generic, mapped := range genericRightsMapping {
+		if mask&generic != 0 {
+			mask = mask&^generic | mapped
+		}
+	}
+	return mask
+}
+
+// inheritable returns whether ACE is inherited by child objects
+func (a accessControlEntry) inheritable() bool {
+	return a.flags&(aceFlagObjectInherit|aceFlagContainerInherit) != 0
+}
+
+// appliesTo returns whether ACE applies to the object with given object classes
+func (a accessControlEntry) appliesTo(classes []string) bool {
+	if a.flags&aceFlagInheritOnly != 0 {
+		return false
+	}
+	// inherited object type limits which child objects inherit the ACE
+	if a.flags&aceFlagInherited == 0 || a.inheritedObjectType == "" {
+		return true
+	}
+	for _, c := range classes {
+		if classGUIDs[strings.ToLower(c)] == a.inheritedObjectType {
+			return true
+		}
+	}
+	return false
+}
+
+// isFilteredSID returns whether ACEs of the principal are ignored,
+// same principals are ignored by SharpHound
+func isFilteredSID(sid string) bool {
+	switch sid {
+	case "S-1-3-0", "S-1-5-18", "S-1-5-10":
+		return true
+	}
+	for _, p := range []string{"S-1-5-80-", "S-1-5-82-", "S-1-5-90-", "S-1-5-96-"} {
+		if strings.HasPrefix(sid, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// aceRight is RightName and AceType of the ace as produced by SharpHound
+type aceRight struct {
+	rightName string
+	aceType   string
+}
+
+// rights maps access mask and object type of the ACE to SharpHound rights
+// of the object with given label. lapsGUIDs are schemaIDGUIDs of LAPS password attributes
+func (a accessControlEntry) rights(label string, hasLAPS bool, lapsGUIDs map[string]bool) []aceRight {
+	var rights []aceRight
+	anyObject := a.objectType == ""
+
+	if a.mask&rightGenericAll != 0 || a.mask&rightFullControl == rightFullControl {
+		if anyObject {
+			rights = append(rights, aceRight{rightName: "GenericAll"})
+			if label == "Computer" && hasLAPS {
+				rights = append(rights, aceRight{rightName: "ReadLAPSPassword"})
+			}
+		} else if label == "Computer" && lapsGUIDs[a.objectType] {
+			rights = append(rights, aceRight{rightName: "ReadLAPSPassword"})
+		}
+		return rights
+	}
+
+	if a.mask&rightWriteDacl != 0 {
+		rights = append(rights, aceRight{rightName: "WriteDacl"})
+	}
+	if a.mask&rightWriteOwner != 0 {
+		rights = append(rights, aceRight{rightName: "WriteOwner"})
+	}
+
+	if a.mask&rightExtended != 0 {
+		switch {
+		case anyObject:
+			rights = append(rights, aceRight{rightName: "ExtendedRight", aceType: "All"})
+			if label == "Computer" && hasLAPS {
+				rights = append(rights, aceRight{rightName: "ReadLAPSPassword"})
+			}
+		case label == "Domain" && a.objectType == guidGetChanges:
+			rights = append(rights, aceRight{rightName: "ExtendedRight", aceType: "GetChanges"})
+		case label == "Domain" && a.objectType == guidGetChangesAll:
+			rights = append(rights, aceRight{rightName: "ExtendedRight", aceType: "GetChangesAll"})
+		case label == "User" && a.objectType == guidForceChangePassword:
+			rights = append(rights, aceRight{rightName: "ExtendedRight", aceType: "User-Force-Change-Password"})
+		case label == "Computer" && lapsGUIDs[a.objectType]:
+			rights = append(rights, aceRight{rightName: "ReadLAPSPassword"})
+		}
+	}
+
+	if a.mask&(rightGenericWrite|rightWriteProperty) != 0 {
+		switch {
+		case anyObject:
+			rights = append(rights, aceRight{rightName: "GenericWrite"})
+		case label == "Group" && a.objectType == guidMember:
+			rights = append(rights, aceRight{rightName: "WriteProperty", aceType: "AddMember"})
+		case label == "Computer" && a.objectType == guidAllowedToActOnBehalf:
+			rights = append(rights, aceRight{rightName: "WriteProperty", aceType: "AllowedToAct"})
+		}
+	} else if a.mask&rightSelf != 0 && label == "Group" && a.objectType == guidMember {
+		rights = append(rights, aceRight{rightName: "WriteProperty", aceType: "AddMember"})
+	}
+	return rights
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testACE is an ACE of test security descriptor, GUIDs are in string form
+type testACE struct {
+	aceType             byte
+	flags               byte
+	mask                uint32
+	objectType          string
+	inheritedObjectType string
+	sid                 string
+}
+
+func guidStringBytes(t *testing.T, guid string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(guid, "-", ""))
+	if err != nil || len(b) != 16 {
+		t.Fatalf("invalid test GUID %s", guid)
+	}
+	// first three parts are little endian
+	return append([]byte{b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6]}, b[8:]...)
+}
+
+// writeSecurityDescriptor builds self relative security descriptor
+func writeSecurityDescriptor(t *testing.T, control uint16, owner string, aces []testACE) []byte {
+	t.Helper()
+	var acl bytes.Buffer
+	for _, a := range aces {
+		var body bytes.Buffer
+		binary.Write(&body, binary.LittleEndian, a.mask)
+		if a.aceType == aceTypeAccessAllowedObject {
+			var flags uint32
+			if a.objectType != "" {
+				flags |= aceObjectTypePresent
+			}
+			if a.inheritedObjectType != "" {
+				flags |= aceInheritedObjectTypePresent
+			}
+			binary.Write(&body, binary.LittleEndian, flags)
+			if a.objectType != "" {
+				body.Write(guidStringBytes(t, a.objectType))
+			}
+			if a.inheritedObjectType != "" {
+				body.Write(guidStringBytes(t, a.inheritedObjectType))
+			}
+		}
+		body.Write(sidBytes(a.sid))
+		acl.Write([]byte{a.aceType, a.flags})
+		binary.Write(&acl, binary.LittleEndian, uint16(4+body.Len()))
+		acl.Write(body.Bytes())
+	}
+
+	ownerSID := sidBytes(owner)
+	var b bytes.Buffer
+	b.Write([]byte{1, 0})
+	binary.Write(&b, binary.LittleEndian, control)
+	binary.Write(&b, binary.LittleEndian, []uint32{20, 0, 0, uint32(20 + len(ownerSID))})
+	b.Write(ownerSID)
+	b.Write([]byte{4, 0})
+	binary.Write(&b, binary.LittleEndian, []uint16{uint16(8 + acl.Len()), uint16(len(aces)), 0})
+	b.Write(acl.Bytes())
+	return b.Bytes()
+}
+
+func Test_parseSecurityDescriptor(t *testing.T) {
+	raw := writeSecurityDescriptor(t, sdControlDACLProtected, "S-1-5-21-1-2-3-512", []testACE{
+		{aceType: aceTypeAccessAllowed, mask: rightFullControl, sid: "S-1-5-21-1-2-3-519"},
+		{aceType: 0x01, mask: rightFullControl, sid: "S-1-1-0"},
+		{aceType: aceTypeAccessAllowedObject, flags: aceFlagInherited, mask: rightWriteProperty, objectType: guidMember, inheritedObjectType: classGUIDs["group"], sid: "S-1-5-21-1-2-3-1105"},
+	})
+
+	sd, err := parseSecurityDescriptor(raw)
+	if err != nil {
+		t.Fatalf("parseSecurityDescriptor() error = %v", err)
+	}
+	want := &securityDescriptor{
+		control: sdControlDACLProtected,
+		owner:   "S-1-5-21-1-2-3-512",
+		dacl: []accessControlEntry{
+			{aceType: aceTypeAccessAllowed, mask: rightFullControl, sid: "S-1-5-21-1-2-3-519"},
+			{aceType: aceTypeAccessAllowedObject, flags: aceFlagInherited, mask: rightWriteProperty, objectType: guidMember, inheritedObjectType: classGUIDs["group"], sid: "S-1-5-21-1-2-3-1105"},
+		},
+	}
+	if diff := cmp.Diff(want, sd, cmp.AllowUnexported(securityDescriptor{}, accessControlEntry{})); diff != "" {
+		t.Errorf("parseSecurityDescriptor() mismatch (-want got):\n%s", diff)
+	}
+	if !sd.daclProtected() {
+		t.Error("daclProtected() = false")
+	}
+
+	if _, err := parseSecurityDescriptor(raw[:len(raw)-10]); err == nil {
+		t.Error("expected error for truncated security descriptor")
+	}
+}
+
+func Test_accessControlEntry_rights(t *testing.T) {
+	lapsGUID := "11111111-2222-3333-4444-555555555555"
+	tests := []struct {
+		name    string
+		ace     accessControlEntry
+		label   string
+		hasLAPS bool
+		want    []aceRight
+	}{
+		{name: "full control", ace: accessControlEntry{mask: rightFullControl}, label: "User", want: []aceRight{{rightName: "GenericAll"}}},
+		{name: "full control of LAPS computer", ace: accessControlEntry{mask: rightFullControl}, label: "Computer", hasLAPS: true, want: []aceRight{{rightName: "GenericAll"}, {rightName: "ReadLAPSPassword"}}},
+		{name: "write dacl and owner", ace: accessControlEntry{mask: rightWriteDacl | rightWriteOwner}, label: "GPO", want: []aceRight{{rightName: "WriteDacl"}, {rightName: "WriteOwner"}}},
+		{name: "generic write", ace: accessControlEntry{mask: 0x20028}, label: "User", want: []aceRight{{rightName: "GenericWrite"}}},
+		{name: "all extended rights", ace: accessControlEntry{mask: rightExtended}, label: "User", want: []aceRight{{rightName: "ExtendedRight", aceType: "All"}}},
+		{name: "force change password", ace: accessControlEntry{mask: rightExtended, objectType: guidForceChangePassword}, label: "User", want: []aceRight{{rightName: "ExtendedRight", aceType: "User-Force-Change-Password"}}},
+		{name: "get changes", ace: accessControlEntry{mask: rightExtended, objectType: guidGetChanges}, label: "Domain", want: []aceRight{{rightName: "ExtendedRight", aceType: "GetChanges"}}},
+		{name: "get changes all", ace: accessControlEntry{mask: rightExtended, objectType: guidGetChangesAll}, label: "Domain", want: []aceRight{{rightName: "ExtendedRight", aceType: "GetChangesAll"}}},
+		{name: "get changes on user", ace: accessControlEntry{mask: rightExtended, objectType: guidGetChanges}, label: "User"},
+		{name: "read LAPS password", ace: accessControlEntry{mask: 0x110, objectType: lapsGUID}, label: "Computer", want: []aceRight{{rightName: "ReadLAPSPassword"}}},
+		{name: "add member", ace: accessControlEntry{mask: rightWriteProperty, objectType: guidMember}, label: "Group", want: []aceRight{{rightName: "WriteProperty", aceType: "AddMember"}}},
+		{name: "add self", ace: accessControlEntry{mask: rightSelf, objectType: guidMember}, label: "Group", want: []aceRight{{rightName: "WriteProperty", aceType: "AddMember"}}},
+		{name: "allowed to act", ace: accessControlEntry{mask: rightWriteProperty, objectType: guidAllowedToActOnBehalf}, label: "Computer", want: []aceRight{{rightName: "WriteProperty", aceType: "AllowedToAct"}}},
+		{name: "read only", ace: accessControlEntry{mask: 0x20094}, label: "User"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.ace.rights(tt.label, tt.hasLAPS, map[string]bool{lapsGUID: true})
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(aceRight{})); diff != "" {
+				t.Errorf("rights() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_convertDirectory_aces(t *testing.T) {
+	domain := newDirectoryEntry("DC=testlab,DC=local")
+	domain.add("objectClass", []byte("domainDNS"))
+	domain.add("objectSid", sidBytes("S-1-5-21-1-2-3"))
+
+	group := newDirectoryEntry("CN=Helpdesk,CN=Users,DC=testlab,DC=local")
+	group.add("objectClass", []byte("group"))
+	group.add("objectSid", sidBytes("S-1-5-21-1-2-3-1105"))
+	group.add("nTSecurityDescriptor", writeSecurityDescriptor(t, 0, "S-1-5-21-1-2-3-512", []testACE{
+		{aceType: aceTypeAccessAllowedObject, flags: aceFlagInherited, mask: rightWriteProperty, objectType: guidMember, inheritedObjectType: classGUIDs["group"], sid: "S-1-5-21-1-2-3-1106"},
+		// inherited only by users
+		{aceType: aceTypeAccessAllowedObject, flags: aceFlagInherited, mask: rightExtended, objectType: guidForceChangePassword, inheritedObjectType: classGUIDs["user"], sid: "S-1-5-21-1-2-3-1106"},
+		{aceType: aceTypeAccessAllowed, mask: rightFullControl, sid: "S-1-5-18"},
+		{aceType: aceTypeAccessAllowed, flags: aceFlagInheritOnly, mask: rightFullControl, sid: "S-1-5-21-1-2-3-1106"},
+	}))
+
+	gmsa := newDirectoryEntry("CN=svc,CN=Managed Service Accounts,DC=testlab,DC=local")
+	gmsa.add("objectClass", []byte("computer"))
+	gmsa.add("objectClass", []byte("msDS-GroupManagedServiceAccount"))
+	gmsa.add("objectSid", sidBytes("S-1-5-21-1-2-3-1107"))
+	gmsa.add("msDS-GroupMSAMembership", writeSecurityDescriptor(t, 0, "S-1-5-32-544", []testACE{
+		{aceType: aceTypeAccessAllowed, mask: rightFullControl, sid: "S-1-5-21-1-2-3-1105"},
+	}))
+
+	data := convertDirectory([]*directoryEntry{domain, group, gmsa}, nil)
+
+	wantGroup := []ace{
+		{PrincipalSID: "S-1-5-21-1-2-3-512", PrincipalType: "Base", RightName: "Owner"},
+		{PrincipalSID: "S-1-5-21-1-2-3-1106", PrincipalType: "Base", RightName: "WriteProperty", AceType: "AddMember", IsInherited: true},
+	}
+	if diff := cmp.Diff(wantGroup, data.Groups[0].Aces); diff != "" {
+		t.Errorf("group aces mismatch (-want got):\n%s", diff)
+	}
+
+	wantGMSA := []ace{{PrincipalSID: "S-1-5-21-1-2-3-1105", PrincipalType: "Group", RightName: "ReadGMSAPassword"}}
+	if diff := cmp.Diff(wantGMSA, data.Users[0].Aces); diff != "" {
+		t.Errorf("gMSA aces mismatch (-want got):\n%s", diff)
+	}
+}
+
+func Test_convertDirectory_aceSource(t *testing.T) {
+	inheritable := byte(aceFlagContainerInherit | aceFlagObjectInherit)
+	domain := newDirectoryEntry("DC=testlab,DC=local")
+	domain.add("objectClass", []byte("domainDNS"))
+	domain.add("objectSid", sidBytes("S-1-5-21-1-2-3"))
+	domain.add("nTSecurityDescriptor", writeSecurityDescriptor(t, 0, "S-1-5-21-1-2-3-512", []testACE{
+		{aceType: aceTypeAccessAllowed, flags: inheritable, mask: rightGenericAll, sid: "S-1-5-21-1-2-3-1106"},
+	}))
+
+	servers := newDirectoryEntry("OU=Servers,DC=testlab,DC=local")
+	servers.add("objectClass", []byte("organizationalUnit"))
+	servers.add("objectGUID", guidBytes(0x11))
+	servers.add("nTSecurityDescriptor", writeSecurityDescriptor(t, 0, "S-1-5-21-1-2-3-512", []testACE{
+		{aceType: aceTypeAccessAllowed, flags: inheritable | aceFlagInherited, mask: rightFullControl, sid: "S-1-5-21-1-2-3-1106"},
+		{aceType: aceTypeAccessAllowed, flags: inheritable | aceFlagInheritOnly, mask: rightWriteDacl, sid: "S-1-5-21-1-2-3-1107"},
+		// same principal is granted different rights by the domain
+		{aceType: aceTypeAccessAllowed, flags: inheritable, mask: rightWriteDacl, sid: "S-1-5-21-1-2-3-1106"},
+	}))
+
+	protected := newDirectoryEntry("OU=Protected,DC=testlab,DC=local")
+	protected.add("objectClass", []byte("organizationalUnit"))
+	protected.add("objectGUID", guidBytes(0x22))
+	protected.add("nTSecurityDescriptor", writeSecurityDescriptor(t, sdControlDACLProtected, "S-1-5-21-1-2-3-512", nil))
+
+	web := newDirectoryEntry("CN=web,OU=Servers,DC=testlab,DC=local")
+	web.add("objectClass", []byte("computer"))
+	web.add("objectSid", sidBytes("S-1-5-21-1-2-3-1108"))
+	web.add("nTSecurityDescriptor", writeSecurityDescriptor(t, 0, "S-1-5-21-1-2-3-512", []testACE{
+		{aceType: aceTypeAccessAllowed, flags: aceFlagInherited, mask: rightFullControl, sid: "S-1-5-21-1-2-3-1106"},
+		{aceType: aceTypeAccessAllowed, flags: aceFlagInherited, mask: rightWriteDacl, sid: "S-1-5-21-1-2-3-1107"},
+		{aceType: aceTypeAccessAllowed, flags: aceFlagInherited, mask: rightWriteDacl, sid: "S-1-5-21-1-2-3-1106"},
+	}))
+
+	db := newDirectoryEntry("CN=db,OU=Protected,DC=testlab,DC=local")
+	db.add("objectClass", []byte("computer"))
+	db.add("objectSid", sidBytes("S-1-5-21-1-2-3-1109"))
+	db.add("nTSecurityDescriptor", writeSecurityDescriptor(t, 0, "S-1-5-21-1-2-3-512", []testACE{
+		{aceType: aceTypeAccessAllowed, flags: aceFlagInherited, mask: rightFullControl, sid: "S-1-5-21-1-2-3-1106"},
+	}))
+
+	data := convertDirectory([]*directoryEntry{domain, servers, protected, web, db}, nil)
+	if len(data.Computers) != 2 {
+		t.Fatalf("expected 2 computers, got %d", len(data.Computers))
+	}
+	serversID := data.OUs[0].ObjectIdentifier
+
+	wantWeb := []ace{
+		{PrincipalSID: "S-1-5-21-1-2-3-512", PrincipalType: "Base", RightName: "Owner"},
+		{PrincipalSID: "S-1-5-21-1-2-3-1106", PrincipalType: "Base", RightName: "GenericAll", IsInherited: true, InheritedFrom: "S-1-5-21-1-2-3"},
+		{PrincipalSID: "S-1-5-21-1-2-3-1107", PrincipalType: "Base", RightName: "WriteDacl", IsInherited: true, InheritedFrom: serversID},
+		{PrincipalSID: "S-1-5-21-1-2-3-1106", PrincipalType: "Base", RightName: "WriteDacl", IsInherited: true, InheritedFrom: serversID},
+	}
+	if diff := cmp.Diff(wantWeb, data.Computers[0].Aces); diff != "" {
+		t.Errorf("web aces mismatch (-want got):\n%s", diff)
+	}
+
+	wantDB := []ace{
+		{PrincipalSID: "S-1-5-21-1-2-3-512", PrincipalType: "Base", RightName: "Owner"},
+		{PrincipalSID: "S-1-5-21-1-2-3-1106", PrincipalType: "Base", RightName: "GenericAll", IsInherited: true},
+	}
+	if diff := cmp.Diff(wantDB, data.Computers[1].Aces); diff != "" {
+		t.Errorf("db aces mismatch (-want got):\n%s", diff)
+	}
+	if data.OUs[0].ACLProtected || !data.OUs[1].ACLProtected {
+		t.Errorf("unexpected ACLProtected %v %v", data.OUs[0].ACLProtected, data.OUs[1].ACLProtected)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// session collection methods recorded in 'sources' of HasSession edges
+const (
+	sessionSourceNetSessionEnum = "NetSessionEnum"
+	sessionSourceLoggedOn       = "LoggedOn"
+	sessionSourceRegistry       = "Registry"
+	// SharpHound 3 and BloodHound.py mix sessions of all methods in a single list
+	sessionSourceUnknown = "Unknown"
+)
+
+// sessionList is a list of sessions, SharpHound 3 and BloodHound.py use an array of sessions,
+// newer SharpHound versions use an object with 'Results' of sessions with UserSID and ComputerSID
+type sessionList []session
+
+func (l *sessionList) UnmarshalJSON(b []byte) error {
+	var sessions []session
+	if err := json.Unmarshal(b, &sessions); err == nil {
+		for i := range sessions {
+			sessions[i].Legacy = true
+		}
+		*l = sessions
+		return nil
+	}
+
+	var result struct {
+		Results []struct {
+			UserSID     string `json:"UserSID"`
+			ComputerSID string `json:"ComputerSID"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return fmt.Errorf("invalid sessions %w", err)
+	}
+	*l = nil
+	for _, r := range result.Results {
+		*l = append(*l, session{UserID: r.UserSID, ComputerID: r.ComputerSID})
+	}
+	return nil
+}
+
+// buildSessionStatement returns HasSession statement which records time session was first and last seen
+// and collection methods it was found by
+func buildSessionStatement() string {
+	// collectors don't record when sessions were collected, time of upload is used
+	// so re-imported old collections refresh it
+	seen := currentTime()
+	return buildRelStatement("Computer", "User", "HasSession", "{isacl:false}") +
+		fmt.Sprintf(" SET r.firstseen = coalesce(r.firstseen, %s), r.lastseen = %s,", seen, seen) +
+		" r.sources = CASE WHEN item.method IN coalesce(r.sources, []) THEN r.sources ELSE coalesce(r.sources, []) + item.method END"
+}
+
+// expireSessionsStatement returns statement which deletes HasSession edges not seen in $days days.
+// cutoff is computed by the server as 'lastseen' is set from the server clock
+func expireSessionsStatement() string {
+	cutoff := currentTime() + " - $days * 86400"
+	if timestampFormat == timestampDatetime {
+		cutoff = currentTime() + " - duration({days: $days})"
+	}
+	return fmt.Sprintf(`MATCH ()-[r:HasSession]->()
+			  WHERE r.lastseen < %s
+			  DELETE r
+			  RETURN count(r) as deletedSessionCount`, cutoff)
+}
+
+// expireSessions deletes HasSession edges which weren't seen in given number of days,
+// edges uploaded without 'lastseen' are kept. it returns number of deleted edges
+func expireSessions(driver neo4j.Driver, days int) (int64, error) {
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode: neo4j.AccessModeWrite,
+	})
+	defer session.Close()
+
+	record, err := neo4j.AsRecord(session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		return neo4j.Single(tx.Run(expireSessionsStatement(), map[string]interface{}{"days": days}))
+	}))
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := record.Get("deletedSessionCount")
+	n, _ := deleted.(int64)
+	return n, nil
+}
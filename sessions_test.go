@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_sessionList_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    sessionList
+		wantErr bool
+	}{
+		{
+			name:  "array",
+			input: `[{"UserId": "S-1-5-21-1-1105", "ComputerId": "S-1-5-21-1-1001"}]`,
+			want:  sessionList{{UserID: "S-1-5-21-1-1105", ComputerID: "S-1-5-21-1-1001", Legacy: true}},
+		},
+		{
+			name:  "results",
+			input: `{"Collected": true, "FailureReason": null, "Results": [{"UserSID": "S-1-5-21-1-1105", "ComputerSID": "S-1-5-21-1-1001"}]}`,
+			want:  sessionList{{UserID: "S-1-5-21-1-1105", ComputerID: "S-1-5-21-1-1001"}},
+		},
+		{
+			name:  "not collected",
+			input: `{"Collected": false, "Results": []}`,
+		},
+		{
+			name:    "invalid",
+			input:   `"sessions"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got sessionList
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("UnmarshalJSON() mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_buildComputerCyphers_sessionSources(t *testing.T) {
+	var c computer
+	input := `{
+		"ObjectIdentifier": "S-1-5-21-1-1001",
+		"Sessions": {"Results": [{"UserSID": "S-1-5-21-1-1105", "ComputerSID": "S-1-5-21-1-1001"}]},
+		"PrivilegedSessions": {"Results": [{"UserSID": "S-1-5-21-1-500", "ComputerSID": "S-1-5-21-1-1001"}]},
+		"RegistrySessions": {"Results": [{"UserSID": "S-1-5-21-1-1105", "ComputerSID": "S-1-5-21-1-1001"}]}
+	}`
+	if err := json.Unmarshal([]byte(input), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	st := buildSessionStatement()
+	want := []map[string]interface{}{
+		{"source": "S-1-5-21-1-1001", "target": "S-1-5-21-1-1105", "method": sessionSourceNetSessionEnum},
+		{"source": "S-1-5-21-1-1001", "target": "S-1-5-21-1-500", "method": sessionSourceLoggedOn},
+		{"source": "S-1-5-21-1-1001", "target": "S-1-5-21-1-1105", "method": sessionSourceRegistry},
+	}
+	got := buildComputerCyphers([]computer{c})[hash(st)]
+	if got == nil {
+		t.Fatal("HasSession cypher not found")
+	}
+	if diff := cmp.Diff(want, got.list); diff != "" {
+		t.Errorf("sessions mismatch (-want got):\n%s", diff)
+	}
+}
+
+func Test_buildComputerCyphers_legacySessions(t *testing.T) {
+	var c computer
+	input := `{
+		"ObjectIdentifier": "S-1-5-21-1-1001",
+		"Sessions": [{"UserId": "S-1-5-21-1-1105", "ComputerId": "S-1-5-21-1-1001"}],
+		"PrivilegedSessions": [{"UserId": "S-1-5-21-1-500", "ComputerId": "S-1-5-21-1-1001"}],
+		"RegistrySessions": [{"UserId": "S-1-5-21-1-1106", "ComputerId": "S-1-5-21-1-1001"}]
+	}`
+	if err := json.Unmarshal([]byte(input), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	st := buildSessionStatement()
+	want := []map[string]interface{}{
+		{"source": "S-1-5-21-1-1001", "target": "S-1-5-21-1-1105", "method": sessionSourceUnknown},
+		{"source": "S-1-5-21-1-1001", "target": "S-1-5-21-1-500", "method": sessionSourceLoggedOn},
+		{"source": "S-1-5-21-1-1001", "target": "S-1-5-21-1-1106", "method": sessionSourceRegistry},
+	}
+	got := buildComputerCyphers([]computer{c})[hash(st)]
+	if got == nil {
+		t.Fatal("HasSession cypher not found")
+	}
+	if diff := cmp.Diff(want, got.list); diff != "" {
+		t.Errorf("sessions mismatch (-want got):\n%s", diff)
+	}
+}
+
+func Test_expireSessionsStatement(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: timestampEpoch, want: "WHERE r.lastseen < timestamp() / 1000 - $days * 86400"},
+		{format: timestampDatetime, want: "WHERE r.lastseen < datetime() - duration({days: $days})"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			timestampFormat = tt.format
+			defer func() { timestampFormat = timestampEpoch }()
+			if got := expireSessionsStatement(); !strings.Contains(got, tt.want) {
+				t.Errorf("expireSessionsStatement() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
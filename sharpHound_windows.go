@@ -23,7 +23,7 @@ import (
 var f embed.FS
 
 func execSharpHound(ctx context.Context, c *cli.Context) error {
-	shArgs := []string{"--NoZip", "--OutputDirectory", c.String("bhi-target-directory")}
+	shArgs := []string{"--NoZip", "--OutputDirectory", c.StringSlice("bhi-target-directory")[0]}
 
 	for _, flag := range c.FlagNames() {
 		if strings.HasPrefix(flag, "bhi") {
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fileStatus tracks progress of a single input file through processor and uploader
+type fileStatus struct {
+	start     time.Time // processing of the file started
+	queued    bool      // all batches of the file were sent to uploader
+	batches   int       // number of batches sent to uploader
+	committed int       // number of batches committed to DB
+	err       error
+}
+
+func (fs *fileStatus) loaded() bool {
+	return fs.err == nil && fs.queued && fs.committed == fs.batches
+}
+
+// importSummary is shared by processors and uploader to distinguish
+// completely loaded files from failed or cancelled ones
+type importSummary struct {
+	mu    sync.Mutex
+	files map[string]*fileStatus
+}
+
+func newImportSummary(files []string) *importSummary {
+	s := &importSummary{files: make(map[string]*fileStatus)}
+	for _, f := range files {
+		s.files[f] = &fileStatus{}
+	}
+	return s
+}
+
+func (s *importSummary) status(file string) *fileStatus {
+	fs, ok := s.files[file]
+	if !ok {
+		fs = &fileStatus{}
+		s.files[file] = fs
+	}
+	return fs
+}
+
+// started is called by processor when it starts processing the file
+// so its duration doesn't include time spent waiting for other files
+func (s *importSummary) started(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status(file).start = time.Now()
+}
+
+// sent is called by processor for every batch sent to uploader
+func (s *importSummary) sent(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status(file).batches++
+}
+
+// queued is called by processor once all batches of the file are sent
+func (s *importSummary) queued(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs := s.status(file)
+	fs.queued = true
+	s.logIfLoaded(file, fs)
+}
+
+// committed is called by uploader once batch of the file is committed
+func (s *importSummary) committed(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs := s.status(file)
+	fs.committed++
+	s.logIfLoaded(file, fs)
+}
+
+// failed records error which stopped file from being fully loaded
+func (s *importSummary) failed(file string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs := s.status(file)
+	if fs.err == nil {
+		fs.err = err
+	}
+}
+
+func (s *importSummary) logIfLoaded(file string, fs *fileStatus) {
+	if fs.loaded() {
+		log.WithFields(logrus.Fields{
+			"file":        file,
+			"duration_ms": time.Since(fs.start).Milliseconds(),
+		}).Info("finished uploading data")
+	}
+}
+
+// result returns sorted lists of loaded and not loaded files
+func (s *importSummary) result() (loaded, notLoaded []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for f, fs := range s.files {
+		if fs.loaded() {
+			loaded = append(loaded, f)
+		} else {
+			notLoaded = append(notLoaded, f)
+		}
+	}
+	sort.Strings(loaded)
+	sort.Strings(notLoaded)
+	return loaded, notLoaded
+}
+
+// log writes status of every file and returns number of files not loaded
+func (s *importSummary) log() int {
+	loaded, notLoaded := s.result()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range notLoaded {
+		fs := s.files[f]
+		logger := log.WithFields(logrus.Fields{
+			"file":      f,
+			"batches":   fs.batches,
+			"committed": fs.committed,
+		})
+		if fs.err != nil {
+			logger = logger.WithError(fs.err)
+		}
+		logger.Warn("file was not fully loaded")
+	}
+	log.WithFields(logrus.Fields{
+		"loaded":     len(loaded),
+		"not_loaded": len(notLoaded),
+	}).Info("import summary")
+	return len(notLoaded)
+}
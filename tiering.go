@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"gopkg.in/yaml.v2"
+)
+
+// labels and edge types are written into cypher statements so only identifiers are allowed
+var cypherIdentifierRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// tieringConfig classifies nodes after upload, rules are applied in order
+type tieringConfig struct {
+	Rules []tierRule `json:"rules" yaml:"rules"`
+}
+
+// tierRule matches nodes by any of its selectors and sets tier, highvalue and labels of them.
+// nodes matched by multiple rules keep the lowest tier and highvalue of the rule it comes from
+type tierRule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Tier      *int     `json:"tier" yaml:"tier"`
+	HighValue *bool    `json:"highvalue" yaml:"highvalue"`
+	Labels    []string `json:"labels" yaml:"labels"`
+	// selectors
+	SIDs   []string `json:"sids" yaml:"sids"`
+	Names  []string `json:"names" yaml:"names"`
+	OUs    []string `json:"ous" yaml:"ous"`
+	Groups []string `json:"groups" yaml:"groups"`
+}
+
+// loadTieringConfig reads tiering config, file is parsed as yaml which also accepts json
+func loadTieringConfig(file string) (*tieringConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tiering config %w", err)
+	}
+	var cfg tieringConfig
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid tiering config %s: %w", file, err)
+	}
+	for i, r := range cfg.Rules {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("invalid tiering rule %d %q: %w", i+1, r.Name, err)
+		}
+	}
+	return &cfg, nil
+}
+
+func (r tierRule) validate() error {
+	if len(r.SIDs)+len(r.Names)+len(r.OUs)+len(r.Groups) == 0 {
+		return fmt.Errorf("rule doesn't have any selector")
+	}
+	if r.Tier == nil && r.HighValue == nil && len(r.Labels) == 0 {
+		return fmt.Errorf("rule doesn't set tier, highvalue or labels")
+	}
+	if r.Tier != nil && *r.Tier < 0 {
+		return fmt.Errorf("tier can't be negative")
+	}
+	for _, l := range r.Labels {
+		if !cypherIdentifierRegex.MatchString(l) {
+			return fmt.Errorf("invalid label %q, only letters, digits and '_' are allowed", l)
+		}
+		if _, ok := knownLabels[strings.ToLower(l)]; ok {
+			return fmt.Errorf("label %q is used by bloodhound", l)
+		}
+	}
+	for _, n := range r.Names {
+		if n == "" {
+			return fmt.Errorf("name pattern can't be empty")
+		}
+	}
+	return nil
+}
+
+// validatePatterns checks name patterns of the rules on the server as they are matched
+// with cypher '=~' which uses java regex syntax, connectivity errors are returned as they are
+func (cfg *tieringConfig) validatePatterns(driver neo4j.Driver) error {
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode: neo4j.AccessModeRead,
+	})
+	defer session.Close()
+
+	for i, r := range cfg.Rules {
+		for _, p := range r.Names {
+			_, err := session.ReadTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+				return neo4j.Single(tx.Run("RETURN '' =~ $pattern", map[string]interface{}{"pattern": p}))
+			})
+			if isNeo4jConnectivityError(err) {
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("invalid tiering rule %d %q: invalid name pattern %q: %w", i+1, r.Name, p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// highValue returns highvalue set by the rule, tier 0 is high value unless set otherwise
+func (r tierRule) highValue() *bool {
+	if r.HighValue == nil && r.Tier != nil && *r.Tier == 0 {
+		hv := true
+		return &hv
+	}
+	return r.HighValue
+}
+
+// tieringMaxNesting is the maximum depth of nested group membership followed by group selectors
+const tieringMaxNesting = 20
+
+// groupMembersStatement returns objectids of direct and nested members of $groups given by objectid or name,
+// membership is expanded from the groups so only their members are visited
+var groupMembersStatement = fmt.Sprintf(`MATCH (g:Group) WHERE g.objectid IN $groups OR g.name IN $groups
+MATCH (n)-[:MemberOf*1..%d]->(g)
+RETURN DISTINCT n.objectid AS objectid`, tieringMaxNesting)
+
+// statement returns cypher statement and its parameters which applies the rule.
+// names are matched with cypher regex against whole name, OUs match objects in the OU
+// or its child OUs by distinguished name and groups match members, objectids of direct
+// and nested members of the groups are returned by groupMembersStatement
+func (r tierRule) statement(members []string) (string, map[string]interface{}) {
+	params := map[string]interface{}{}
+	var conditions []string
+	if len(r.SIDs) > 0 {
+		params["sids"] = upper(r.SIDs)
+		conditions = append(conditions, "n.objectid IN $sids")
+	}
+	if len(r.Names) > 0 {
+		params["names"] = r.Names
+		conditions = append(conditions, "any(p IN $names WHERE n.name =~ p)")
+	}
+	if len(r.OUs) > 0 {
+		var suffixes []string
+		for _, ou := range upper(r.OUs) {
+			suffixes = append(suffixes, ","+ou)
+		}
+		params["ous"] = upper(r.OUs)
+		params["ousuffixes"] = suffixes
+		conditions = append(conditions,
+			"(toUpper(n.distinguishedname) IN $ous OR any(s IN $ousuffixes WHERE toUpper(n.distinguishedname) ENDS WITH s))")
+	}
+	if len(r.Groups) > 0 {
+		if members == nil {
+			members = []string{}
+		}
+		params["members"] = members
+		conditions = append(conditions, "n.objectid IN $members")
+	}
+
+	statement := "MATCH (n:Base) WHERE " + strings.Join(conditions, " OR ")
+	if r.Tier != nil {
+		params["tier"] = *r.Tier
+		statement += " SET n.tier = CASE WHEN n.tier IS NULL OR n.tier > $tier THEN $tier ELSE n.tier END"
+	}
+	// highvalue of rule with tier is only set if the node keeps its tier,
+	// so highvalue is decided by the same rule as tier of nodes matched by multiple rules
+	if hv := r.highValue(); hv != nil {
+		params["highvalue"] = *hv
+		if r.Tier != nil {
+			statement += " SET n.highvalue = CASE WHEN n.tier = $tier THEN $highvalue ELSE n.highvalue END"
+		} else {
+			statement += " SET n.highvalue = $highvalue"
+		}
+	}
+	if len(r.Labels) > 0 {
+		statement += " SET n:" + strings.Join(r.Labels, ":")
+	}
+	return statement + " RETURN count(n) AS matched", params
+}
+
+func upper(values []string) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = strings.ToUpper(v)
+	}
+	return result
+}
+
+// resetStatements return statements which remove tier and labels set by previous runs
+// so nodes which don't match any rule any more aren't classified. highvalue isn't reset
+// as it can't be distinguished from highvalue set by the collector
+func (cfg *tieringConfig) resetStatements() []string {
+	statements := []string{"MATCH (n:Base) WHERE n.tier IS NOT NULL REMOVE n.tier"}
+	seen := map[string]bool{}
+	for _, r := range cfg.Rules {
+		for _, l := range r.Labels {
+			if !seen[l] {
+				seen[l] = true
+				statements = append(statements, fmt.Sprintf("MATCH (n:%s) REMOVE n:%s", l, l))
+			}
+		}
+	}
+	return statements
+}
+
+// applyTiering applies rules of the config in a single transaction, it returns number of nodes matched by each rule
+func applyTiering(driver neo4j.Driver, cfg *tieringConfig) ([]int64, error) {
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode: neo4j.AccessModeWrite,
+	})
+	defer session.Close()
+
+	matched, err := session.WriteTransaction(func(tx neo4j.Transaction) (interface{}, error) {
+		for _, st := range cfg.resetStatements() {
+			if _, err := tx.Run(st, nil); err != nil {
+				return nil, err
+			}
+		}
+		matched := make([]int64, 0, len(cfg.Rules))
+		for _, r := range cfg.Rules {
+			var members []string
+			if len(r.Groups) > 0 {
+				res, err := tx.Run(groupMembersStatement, map[string]interface{}{"groups": upper(r.Groups)})
+				if err != nil {
+					return nil, err
+				}
+				for res.Next() {
+					if id, ok := res.Record().Values[0].(string); ok {
+						members = append(members, id)
+					}
+				}
+				if err := res.Err(); err != nil {
+					return nil, err
+				}
+			}
+			st, params := r.statement(members)
+			record, err := neo4j.Single(tx.Run(st, params))
+			if err != nil {
+				return nil, err
+			}
+			n, _ := record.Values[0].(int64)
+			matched = append(matched, n)
+		}
+		return matched, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matched.([]int64), nil
+}
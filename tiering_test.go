@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_loadTieringConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "yaml", config: `
+rules:
+  - name: tier 0
+    tier: 0
+    labels: [Tier0]
+    sids: [S-1-5-21-1-512]
+    groups: [ENTERPRISE ADMINS@TESTLAB.LOCAL]
+  - name: servers
+    tier: 1
+    ous: ["OU=Servers,DC=testlab,DC=local"]
+`},
+		{name: "json", config: `{"rules": [{"name": "dc", "highvalue": true, "names": ["^DC\\d+\\..*"]}]}`},
+		{name: "without selector", config: `{"rules": [{"tier": 0}]}`, wantErr: true},
+		{name: "without action", config: `{"rules": [{"sids": ["S-1-5-21-1-512"]}]}`, wantErr: true},
+		{name: "known label", config: `{"rules": [{"labels": ["Group"], "sids": ["S-1-5-21-1-512"]}]}`, wantErr: true},
+		{name: "invalid label", config: `{"rules": [{"labels": ["Tier0) DETACH DELETE n //"], "sids": ["S-1-5-21-1-512"]}]}`, wantErr: true},
+		{name: "java regex pattern", config: `{"rules": [{"tier": 0, "names": ["(?=DC)\\w++\\..*"]}]}`},
+		{name: "empty pattern", config: `{"rules": [{"tier": 0, "names": [""]}]}`, wantErr: true},
+		{name: "unknown field", config: `{"rules": [{"tier": 0, "sid": ["S-1-5-21-1-512"]}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "tiering.yaml")
+			if err := ioutil.WriteFile(file, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadTieringConfig(file)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadTieringConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_tierRule_statement(t *testing.T) {
+	tier0, tier1 := 0, 1
+	notHighValue := false
+	tests := []struct {
+		name       string
+		rule       tierRule
+		members    []string
+		wantStmt   string
+		wantParams map[string]interface{}
+	}{
+		{
+			name:    "tier 0 is high value",
+			rule:    tierRule{Tier: &tier0, Labels: []string{"Tier0", "Critical"}, SIDs: []string{"s-1-5-21-1-512"}, Groups: []string{"Domain Admins@testlab.local"}},
+			members: []string{"S-1-5-21-1-500"},
+			wantStmt: "MATCH (n:Base) WHERE n.objectid IN $sids OR n.objectid IN $members" +
+				" SET n.tier = CASE WHEN n.tier IS NULL OR n.tier > $tier THEN $tier ELSE n.tier END SET n.highvalue = CASE WHEN n.tier = $tier THEN $highvalue ELSE n.highvalue END SET n:Tier0:Critical RETURN count(n) AS matched",
+			wantParams: map[string]interface{}{
+				"sids": []string{"S-1-5-21-1-512"}, "members": []string{"S-1-5-21-1-500"}, "tier": 0, "highvalue": true,
+			},
+		},
+		{
+			name: "names and OUs",
+			rule: tierRule{Tier: &tier1, HighValue: &notHighValue, Names: []string{"^SRV.*"}, OUs: []string{"OU=Servers,DC=testlab,DC=local"}},
+			wantStmt: "MATCH (n:Base) WHERE any(p IN $names WHERE n.name =~ p) OR (toUpper(n.distinguishedname) IN $ous OR any(s IN $ousuffixes WHERE toUpper(n.distinguishedname) ENDS WITH s))" +
+				" SET n.tier = CASE WHEN n.tier IS NULL OR n.tier > $tier THEN $tier ELSE n.tier END SET n.highvalue = CASE WHEN n.tier = $tier THEN $highvalue ELSE n.highvalue END RETURN count(n) AS matched",
+			wantParams: map[string]interface{}{
+				"names": []string{"^SRV.*"}, "ous": []string{"OU=SERVERS,DC=TESTLAB,DC=LOCAL"}, "ousuffixes": []string{",OU=SERVERS,DC=TESTLAB,DC=LOCAL"},
+				"tier": 1, "highvalue": false,
+			},
+		},
+		{
+			name:     "group without members",
+			rule:     tierRule{Labels: []string{"Admins"}, Groups: []string{"S-1-5-21-1-544"}},
+			wantStmt: "MATCH (n:Base) WHERE n.objectid IN $members SET n:Admins RETURN count(n) AS matched",
+			wantParams: map[string]interface{}{
+				"members": []string{},
+			},
+		},
+		{
+			name:     "high value without tier",
+			rule:     tierRule{HighValue: &notHighValue, SIDs: []string{"S-1-5-21-1-1105"}},
+			wantStmt: "MATCH (n:Base) WHERE n.objectid IN $sids SET n.highvalue = $highvalue RETURN count(n) AS matched",
+			wantParams: map[string]interface{}{
+				"sids": []string{"S-1-5-21-1-1105"}, "highvalue": false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, params := tt.rule.statement(tt.members)
+			if diff := cmp.Diff(tt.wantStmt, stmt); diff != "" {
+				t.Errorf("statement() mismatch (-want got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantParams, params); diff != "" {
+				t.Errorf("statement() params mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+// SharpHound trust types
+const (
+	trustTypeParentChild = 0
+	trustTypeCrossLink   = 1
+	trustTypeForest      = 2
+	trustTypeExternal    = 3
+)
+
+var trustTypeNames = map[int]string{
+	trustTypeParentChild: "ParentChild",
+	trustTypeCrossLink:   "CrossLink",
+	trustTypeForest:      "Forest",
+	trustTypeExternal:    "External",
+	4:                    "Unknown",
+}
+
+var trustDirectionNames = map[int]string{
+	0: "Disabled",
+	1: "Inbound",
+	2: "Outbound",
+	3: "Bidirectional",
+}
+
+// trustTypeName returns name of SharpHound trust type, types which aren't known are 'Unknown'
+func trustTypeName(t int) string {
+	if name, ok := trustTypeNames[t]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+func trustDirectionName(d int) string {
+	if name, ok := trustDirectionNames[d]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// withinForest returns whether both domains of the trust are in the same forest
+func (t trust) withinForest() bool {
+	return t.TrustType == trustTypeParentChild || t.TrustType == trustTypeCrossLink ||
+		t.TrustAttributes&trustAttributeWithinForest != 0
+}
+
+// sidHistoryAbusable returns whether SIDs added to SID history in the trusted domain
+// are accepted by the trusting domain, so trust can be used to escalate across it.
+// SIDs aren't filtered within forest unless quarantined. forest trusts filter SIDs
+// outside of the trusted forest unless they are treated as external, then only
+// built-in SIDs (RID < 1000) are filtered. external trusts are quarantined by default
+func (t trust) sidHistoryAbusable() bool {
+	if t.SidFilteringEnabled || t.TrustAttributes&trustAttributeQuarantinedDomain != 0 {
+		return false
+	}
+	if t.TrustType == trustTypeForest && !t.withinForest() {
+		return t.TrustAttributes&trustAttributeTreatAsExternal != 0
+	}
+	return true
+}
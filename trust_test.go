@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_trust_sidHistoryAbusable(t *testing.T) {
+	tests := []struct {
+		name  string
+		trust trust
+		want  bool
+	}{
+		{name: "parent child", trust: trust{TrustType: trustTypeParentChild, TrustAttributes: trustAttributeWithinForest}, want: true},
+		{name: "quarantined parent child", trust: trust{TrustType: trustTypeParentChild, SidFilteringEnabled: true}, want: false},
+		{name: "forest", trust: trust{TrustType: trustTypeForest, TrustAttributes: trustAttributeForestTransitive}, want: false},
+		{name: "forest treated as external", trust: trust{TrustType: trustTypeForest, TrustAttributes: trustAttributeForestTransitive | trustAttributeTreatAsExternal}, want: true},
+		{name: "external", trust: trust{TrustType: trustTypeExternal, SidFilteringEnabled: true, TrustAttributes: trustAttributeQuarantinedDomain}, want: false},
+		{name: "external without SID filtering", trust: trust{TrustType: trustTypeExternal}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.trust.sidHistoryAbusable(); got != tt.want {
+				t.Errorf("sidHistoryAbusable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_addTrustCyphers(t *testing.T) {
+	cyphers := make(map[string]*cypher)
+	addTrustCyphers(cyphers, trust{TargetDomainName: "OLD.LOCAL", TrustType: 5, TrustDirection: 0}, "S-1-5-21-1")
+	addTrustCyphers(cyphers, trust{TargetDomainSid: "S-1-5-21-2", TargetDomainName: "CHILD.TESTLAB.LOCAL", TrustDirection: 2, IsTransitive: true,
+		TrustAttributes: trustAttributeWithinForest}, "S-1-5-21-1")
+
+	node := buildNodeStatement("Domain")
+	disabled := buildTrustStatement("DisabledTrust")
+	trusted := buildTrustStatement("TrustedBy")
+	expected := map[string]*cypher{
+		hash(node): {statement: node, list: []map[string]interface{}{
+			{"objectid": "OLD.LOCAL", "properties": map[string]interface{}{"name": "OLD.LOCAL"}},
+			{"objectid": "S-1-5-21-2", "properties": map[string]interface{}{"name": "CHILD.TESTLAB.LOCAL", "objectid": "S-1-5-21-2", "domainsid": "S-1-5-21-2"}},
+		}},
+		hash(disabled): {statement: disabled, list: []map[string]interface{}{{
+			"source": "S-1-5-21-1", "target": "OLD.LOCAL", "trusttype": "Unknown", "trusttypevalue": 5, "trustdirection": "Disabled",
+			"trustattributes": int64(0), "transitive": false, "sidfiltering": false, "withinforest": false, "sidhistoryabusable": true,
+		}}},
+		hash(trusted): {statement: trusted, list: []map[string]interface{}{{
+			"source": "S-1-5-21-2", "target": "S-1-5-21-1", "trusttype": "ParentChild", "trusttypevalue": 0, "trustdirection": "Outbound",
+			"trustattributes": int64(trustAttributeWithinForest), "transitive": true, "sidfiltering": false, "withinforest": true, "sidhistoryabusable": true,
+		}}},
+		hash(staleTrustsStatement): {statement: staleTrustsStatement, list: []map[string]interface{}{
+			{"domain": "S-1-5-21-1", "trusted": "OLD.LOCAL", "edges": []string{"DisabledTrust:S-1-5-21-1"}},
+			{"domain": "S-1-5-21-1", "trusted": "S-1-5-21-2", "edges": []string{"TrustedBy:S-1-5-21-2"}},
+		}},
+	}
+	if diff := cmp.Diff(expected, cyphers, cmp.AllowUnexported(cypher{})); diff != "" {
+		t.Errorf("addTrustCyphers() mismatch (-want got):\n%s", diff)
+	}
+}
+
+func Test_addTrustCyphers_reimport(t *testing.T) {
+	// import applies statements of addTrustCyphers to edges 'type:source->target'
+	// of the graph, it deletes stale edges before merging the trust edges
+	importTrust := func(edges map[string]bool, direction int) {
+		cyphers := make(map[string]*cypher)
+		addTrustCyphers(cyphers, trust{TargetDomainSid: "S-1-5-21-2", TargetDomainName: "EXTERNAL.LOCAL", TrustDirection: direction}, "S-1-5-21-1")
+		for _, item := range cyphers[hash(staleTrustsStatement)].list {
+			for e := range edges {
+				keep := false
+				for _, k := range item["edges"].([]string) {
+					keep = keep || strings.HasPrefix(e, k+"->")
+				}
+				if !keep {
+					delete(edges, e)
+				}
+			}
+		}
+		for _, edgeType := range []string{"TrustedBy", "DisabledTrust"} {
+			if c, ok := cyphers[hash(buildTrustStatement(edgeType))]; ok {
+				for _, item := range c.list {
+					edges[edgeType+":"+item["source"].(string)+"->"+item["target"].(string)] = true
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		previous int
+		current  int
+		want     map[string]bool
+	}{
+		{name: "bidirectional to inbound", previous: 3, current: 1, want: map[string]bool{"TrustedBy:S-1-5-21-1->S-1-5-21-2": true}},
+		{name: "bidirectional to outbound", previous: 3, current: 2, want: map[string]bool{"TrustedBy:S-1-5-21-2->S-1-5-21-1": true}},
+		{name: "inbound to outbound", previous: 1, current: 2, want: map[string]bool{"TrustedBy:S-1-5-21-2->S-1-5-21-1": true}},
+		{name: "bidirectional to disabled", previous: 3, current: 0, want: map[string]bool{"DisabledTrust:S-1-5-21-1->S-1-5-21-2": true}},
+		{name: "disabled to bidirectional", previous: 0, current: 3, want: map[string]bool{
+			"TrustedBy:S-1-5-21-1->S-1-5-21-2": true,
+			"TrustedBy:S-1-5-21-2->S-1-5-21-1": true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edges := make(map[string]bool)
+			importTrust(edges, tt.previous)
+			importTrust(edges, tt.current)
+			if diff := cmp.Diff(tt.want, edges); diff != "" {
+				t.Errorf("trust edges after re-import mismatch (-want got):\n%s", diff)
+			}
+		})
+	}
+}
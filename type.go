@@ -1,5 +1,7 @@
 package main
 
+import "encoding/json"
+
 type bloodHoundRawData struct {
 	Gpos      []gpo      `json:"gpos"`
 	Domains   []domain   `json:"domains"`
@@ -7,44 +9,55 @@ type bloodHoundRawData struct {
 	Groups    []group    `json:"groups"`
 	OUs       []ou       `json:"ous"`
 	Users     []user     `json:"users"`
+	// custom data
+	Nodes []customNode `json:"nodes"`
+	Edges []customEdge `json:"edges"`
+
+	Meta meta `json:"meta"`
+}
 
-	Meta struct {
-		// Possible types are: users, groups, ous, computers, gpos, domains
-		Type    string `json:"type"`
-		Count   int    `json:"count"`
-		Version int    `json:"version"`
-	} `json:"meta"`
+type meta struct {
+	// Possible types are: users, groups, ous, computers, gpos, domains, nodes, edges
+	Type    string `json:"type"`
+	Count   int    `json:"count"`
+	Version int    `json:"version"`
 }
 
 type domain struct {
-	ObjectIdentifier string                 `json:"ObjectIdentifier"`
-	Properties       map[string]interface{} `json:"Properties"`
-	Users            []string               `json:"Users"`
-	Computers        []string               `json:"Computers"`
-	ChildOus         []string               `json:"ChildOus"`
-	Trusts           []struct {
-		TargetDomainSid     string `json:"TargetDomainSid"`
-		IsTransitive        bool   `json:"IsTransitive"`
-		TrustDirection      int    `json:"TrustDirection"`
-		TrustType           int    `json:"TrustType"`
-		SidFilteringEnabled bool   `json:"SidFilteringEnabled"`
-		TargetDomainName    string `json:"TargetDomainName"`
-	} `json:"Trusts"`
-	Links              []link   `json:"Links"`
-	RemoteDesktopUsers []member `json:"RemoteDesktopUsers"`
-	LocalAdmins        []member `json:"LocalAdmins"`
-	DcomUsers          []member `json:"DcomUsers"`
-	PSRemoteUsers      []member `json:"PSRemoteUsers"`
-	Aces               []ace    `json:"Aces"`
+	ObjectIdentifier   string                 `json:"ObjectIdentifier"`
+	Properties         map[string]interface{} `json:"Properties"`
+	Users              []string               `json:"Users"`
+	Computers          []string               `json:"Computers"`
+	ChildOus           []string               `json:"ChildOus"`
+	Trusts             []trust                `json:"Trusts"`
+	Links              []link                 `json:"Links"`
+	RemoteDesktopUsers []member               `json:"RemoteDesktopUsers"`
+	LocalAdmins        []member               `json:"LocalAdmins"`
+	DcomUsers          []member               `json:"DcomUsers"`
+	PSRemoteUsers      []member               `json:"PSRemoteUsers"`
+	Aces               []ace                  `json:"Aces"`
+}
+
+type trust struct {
+	TargetDomainSid     string `json:"TargetDomainSid"`
+	IsTransitive        bool   `json:"IsTransitive"`
+	TrustDirection      int    `json:"TrustDirection"`
+	TrustType           int    `json:"TrustType"`
+	SidFilteringEnabled bool   `json:"SidFilteringEnabled"`
+	TargetDomainName    string `json:"TargetDomainName"`
+	// TrustAttributes are raw trustAttributes, SharpHound 3 doesn't provide them
+	TrustAttributes int64 `json:"TrustAttributes"`
 }
 
 type computer struct {
 	ObjectIdentifier   string                 `json:"ObjectIdentifier"`
 	Properties         map[string]interface{} `json:"Properties"`
-	AllowedToDelegate  []string               `json:"AllowedToDelegate"`
+	AllowedToDelegate  []delegateTarget       `json:"AllowedToDelegate"`
 	AllowedToAct       []member               `json:"AllowedToAct"`
 	PrimaryGroupSid    string                 `json:"PrimaryGroupSid"`
-	Sessions           []session              `json:"Sessions"`
+	Sessions           sessionList            `json:"Sessions"`
+	PrivilegedSessions sessionList            `json:"PrivilegedSessions"`
+	RegistrySessions   sessionList            `json:"RegistrySessions"`
 	LocalAdmins        []member               `json:"LocalAdmins"`
 	RemoteDesktopUsers []member               `json:"RemoteDesktopUsers"`
 	DcomUsers          []member               `json:"DcomUsers"`
@@ -55,7 +68,7 @@ type computer struct {
 type user struct {
 	ObjectIdentifier  string                 `json:"ObjectIdentifier"`
 	Properties        map[string]interface{} `json:"Properties"`
-	AllowedToDelegate []string               `json:"AllowedToDelegate"`
+	AllowedToDelegate []delegateTarget       `json:"AllowedToDelegate"`
 	SPNTargets        []spnTarget            `json:"SPNTargets"`
 	PrimaryGroupSid   string                 `json:"PrimaryGroupSid"`
 	HasSIDHistory     []member               `json:"HasSIDHistory"`
@@ -94,6 +107,9 @@ type ou struct {
 type session struct {
 	UserID     string `json:"UserId"`
 	ComputerID string `json:"ComputerId"`
+	// Legacy is set for sessions decoded from array form, which SharpHound 3 and
+	// BloodHound.py use for sessions of all collection methods mixed together
+	Legacy bool `json:"-"`
 }
 
 type ace struct {
@@ -102,6 +118,9 @@ type ace struct {
 	RightName     string `json:"RightName"`
 	AceType       string `json:"AceType"`
 	IsInherited   bool   `json:"IsInherited"`
+	// InheritedFrom is objectid of the container inherited ACE originates from,
+	// it's only resolved for directory data where parent security descriptors are known
+	InheritedFrom string `json:"-"`
 }
 
 type member struct {
@@ -114,6 +133,25 @@ type link struct {
 	GUID       string `json:"Guid"`
 }
 
+// delegateTarget is target of constrained delegation. SharpHound 3 only provides objectid,
+// newer collectors also provide type of the target, SPNs are only known for directory data
+type delegateTarget struct {
+	ObjectIdentifier string   `json:"ObjectIdentifier"`
+	ObjectType       string   `json:"ObjectType"`
+	SPNs             []string `json:"-"`
+}
+
+// UnmarshalJSON accepts objectid or object with ObjectIdentifier and ObjectType
+func (t *delegateTarget) UnmarshalJSON(b []byte) error {
+	var id string
+	if err := json.Unmarshal(b, &id); err == nil {
+		*t = delegateTarget{ObjectIdentifier: id}
+		return nil
+	}
+	type plain delegateTarget
+	return json.Unmarshal(b, (*plain)(t))
+}
+
 type spnTarget struct {
 	ComputerSid string `json:"ComputerSid"`
 	Port        int    `json:"Port"`
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// userAccountControl flags
+// https://docs.microsoft.com/en-us/troubleshoot/windows-server/identity/useraccountcontrol-manipulate-account-properties
+const (
+	uacAccountDisable             = 0x2
+	uacPasswordNotRequired        = 0x20
+	uacDontExpirePassword         = 0x10000
+	uacTrustedForDelegation       = 0x80000
+	uacNotDelegated               = 0x100000
+	uacDontRequirePreauth         = 0x400000
+	uacTrustedToAuthForDelegation = 0x1000000
+)
+
+// uacProperty is boolean property derived from userAccountControl flag
+type uacProperty struct {
+	name   string
+	flag   int64
+	negate bool
+}
+
+// properties SharpHound sets from userAccountControl
+var (
+	userUACProperties = []uacProperty{
+		{name: "enabled", flag: uacAccountDisable, negate: true},
+		{name: "passwordnotreqd", flag: uacPasswordNotRequired},
+		{name: "pwdneverexpires", flag: uacDontExpirePassword},
+		{name: "unconstraineddelegation", flag: uacTrustedForDelegation},
+		{name: "sensitive", flag: uacNotDelegated},
+		{name: "dontreqpreauth", flag: uacDontRequirePreauth},
+		{name: "trustedtoauth", flag: uacTrustedToAuthForDelegation},
+	}
+	computerUACProperties = []uacProperty{
+		{name: "enabled", flag: uacAccountDisable, negate: true},
+		{name: "unconstraineddelegation", flag: uacTrustedForDelegation},
+		{name: "trustedtoauth", flag: uacTrustedToAuthForDelegation},
+	}
+)
+
+// userAccountControl returns 'useraccountcontrol' property as integer,
+// value can be decoded from json as number or string
+func userAccountControl(props map[string]interface{}) (int64, bool) {
+	switch v := props["useraccountcontrol"].(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// trustedToAuth returns whether account can use protocol transition for constrained
+// delegation, from 'trustedtoauth' property or raw 'useraccountcontrol'
+func trustedToAuth(props map[string]interface{}) bool {
+	if v, ok := coerceProperty(props["trustedtoauth"], propertyBool); ok {
+		return v.(bool)
+	}
+	uac, ok := userAccountControl(props)
+	return ok && uac&uacTrustedToAuthForDelegation != 0
+}
+
+// deriveUACProperties sets properties derived from raw 'useraccountcontrol'
+// which are missing, properties already set by collector are kept.
+// props isn't modified, copy is returned when any property is added
+func deriveUACProperties(props map[string]interface{}, uacProps []uacProperty) map[string]interface{} {
+	uac, ok := userAccountControl(props)
+	if !ok {
+		return props
+	}
+	var derived map[string]interface{}
+	for _, p := range uacProps {
+		if v, ok := props[p.name]; ok && v != nil {
+			continue
+		}
+		if derived == nil {
+			derived = make(map[string]interface{}, len(props)+len(uacProps))
+			for k, v := range props {
+				derived[k] = v
+			}
+		}
+		derived[p.name] = (uac&p.flag != 0) != p.negate
+	}
+	if derived == nil {
+		return props
+	}
+	return derived
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_deriveUACProperties(t *testing.T) {
+	tests := []struct {
+		name     string
+		props    map[string]interface{}
+		uacProps []uacProperty
+		want     map[string]interface{}
+	}{
+		{
+			name:     "without useraccountcontrol",
+			props:    map[string]interface{}{"name": "USER@TESTLAB.LOCAL"},
+			uacProps: userUACProperties,
+			want:     map[string]interface{}{"name": "USER@TESTLAB.LOCAL"},
+		},
+		{
+			name:     "enabled user without preauth",
+			props:    map[string]interface{}{"useraccountcontrol": float64(0x400200)},
+			uacProps: userUACProperties,
+			want: map[string]interface{}{
+				"useraccountcontrol":      float64(0x400200),
+				"enabled":                 true,
+				"passwordnotreqd":         false,
+				"pwdneverexpires":         false,
+				"unconstraineddelegation": false,
+				"sensitive":               false,
+				"dontreqpreauth":          true,
+				"trustedtoauth":           false,
+			},
+		},
+		{
+			name:     "existing properties are kept",
+			props:    map[string]interface{}{"useraccountcontrol": "66050", "enabled": true, "pwdneverexpires": nil},
+			uacProps: userUACProperties,
+			want: map[string]interface{}{
+				"useraccountcontrol":      "66050",
+				"enabled":                 true,
+				"passwordnotreqd":         false,
+				"pwdneverexpires":         true,
+				"unconstraineddelegation": false,
+				"sensitive":               false,
+				"dontreqpreauth":          false,
+				"trustedtoauth":           false,
+			},
+		},
+		{
+			name:     "domain controller",
+			props:    map[string]interface{}{"useraccountcontrol": int64(532480)},
+			uacProps: computerUACProperties,
+			want: map[string]interface{}{
+				"useraccountcontrol":      int64(532480),
+				"enabled":                 true,
+				"unconstraineddelegation": true,
+				"trustedtoauth":           false,
+			},
+		},
+		{
+			name:     "invalid useraccountcontrol",
+			props:    map[string]interface{}{"useraccountcontrol": "disabled"},
+			uacProps: computerUACProperties,
+			want:     map[string]interface{}{"useraccountcontrol": "disabled"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(tt.props)
+			got := deriveUACProperties(tt.props, tt.uacProps)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("deriveUACProperties() mismatch (-want got):\n%s", diff)
+			}
+			if len(tt.props) != before {
+				t.Error("deriveUACProperties() modified properties")
+			}
+		})
+	}
+}
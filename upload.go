@@ -1,16 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+	"github.com/sirupsen/logrus"
 )
 
 func parseFile(file string) (*bloodHoundRawData, error) {
@@ -20,161 +24,350 @@ func parseFile(file string) (*bloodHoundRawData, error) {
 	}
 	defer jsonFile.Close()
 
-	fileByte, err := ioutil.ReadAll(jsonFile)
-	if err != nil {
-		return nil, err
-	}
+	data, _, err := parseData(jsonFile)
+	return data, err
+}
 
-	var bloodHoundData bloodHoundRawData
+// parseData decodes Bloodhound json data from r, it also returns sha256 hash
+// of the content which is used to identify input in checkpoint.
+// data is decoded while its read so remote inputs are streamed
+func parseData(r io.Reader) (*bloodHoundRawData, string, error) {
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(r, h))
 
 	// remove UTF-8 BOM!!
-	fileByte = bytes.TrimPrefix(fileByte, []byte("\xef\xbb\xbf"))
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		if _, err := br.Discard(3); err != nil {
+			return nil, "", err
+		}
+	}
 
-	if err := json.Unmarshal(fileByte, &bloodHoundData); err != nil {
-		return nil, err
+	var bloodHoundData bloodHoundRawData
+	if err := json.NewDecoder(br).Decode(&bloodHoundData); err != nil {
+		return nil, "", err
+	}
+	// read rest of the input so hash covers whole content
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return nil, "", err
+	}
+	bloodHoundData.normalise()
+	if err := bloodHoundData.validateCustom(); err != nil {
+		return nil, "", err
 	}
-	return &bloodHoundData, nil
+	return &bloodHoundData, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// batch is a group of cyphers built from a single slice of objects of an input file
+type batch struct {
+	file     string
+	hash     string
+	metaType string
+	index    int
+	cyphers  map[string]*cypher
 }
 
 func processData(
 	ctx context.Context,
-	wc *sync.WaitGroup,
+	src *inputSource,
 	file string,
-	cypherChan chan<- map[string]*cypher,
-	deleteJsonFile bool,
+	cypherChan chan<- *batch,
+	cp *checkpoint,
+	summary *importSummary,
+	tree *gpoTree,
+	barrier *inputBarrier,
 ) error {
-	defer wc.Done()
-
-	log.Debugf("processing file %s ... ", file)
+	logger := log.WithField("file", file)
+	logger.Debug("processing file")
 
-	data, err := parseFile(file)
+	data, hash, err := parseInput(ctx, src, file)
 	if err != nil {
 		return err
 	}
-	batch := 10
 
-	defer cleanUp(data.Meta.Type, time.Now(), file, deleteJsonFile)
+	types, warnings, err := dataTypes(file, data)
+	for _, w := range warnings {
+		logger.Warn(w)
+	}
+	if err != nil {
+		return parseError(err)
+	}
 
-	switch strings.ToLower(data.Meta.Type) {
-	case "computers":
-		slice := data.Computers
-		for i := 0; i < len(slice); i += batch {
-			j := i + batch
-			if j > len(slice) {
-				j = len(slice)
-			}
-			select {
-			case <-ctx.Done():
-				return nil
-			case cypherChan <- buildComputerCyphers(slice[i:j]):
-			}
+	return queueData(ctx, file, hash, data, types, cypherChan, cp, summary, tree, barrier)
+}
 
-		}
-	case "users":
-		slice := data.Users
-		for i := 0; i < len(slice); i += batch {
-			j := i + batch
-			if j > len(slice) {
-				j = len(slice)
-			}
-			select {
-			case <-ctx.Done():
-				return nil
-			case cypherChan <- buildUserCyphers(slice[i:j]):
-			}
+// parseInput reads and parses input, ADExplorer snapshots, LDIF exports and custom csv data are
+// detected by extension, everything else is parsed as Bloodhound json data.
+// failures to open or read the input are returned as connectivity errors, invalid content as parse errors
+func parseInput(ctx context.Context, src *inputSource, file string) (*bloodHoundRawData, string, error) {
+	input, err := src.open(ctx, file)
+	if err != nil {
+		return nil, "", connectivityError(err)
+	}
+	defer input.Close()
 
-		}
-	case "groups":
-		slice := data.Groups
-		for i := 0; i < len(slice); i += batch {
-			j := i + batch
-			if j > len(slice) {
-				j = len(slice)
-			}
-			select {
-			case <-ctx.Done():
-				return nil
-			case cypherChan <- buildGroupCyphers(slice[i:j]):
-			}
+	r := &inputReader{r: input}
+	var data *bloodHoundRawData
+	var hash string
+	switch inputExt(file) {
+	case ".dat":
+		data, hash, err = parseSnapshot(r.withReaderAt())
+	case ".ldif", ".ldf":
+		data, hash, err = parseLDIF(r)
+	case ".csv":
+		data, hash, err = parseCustomCSV(r)
+	default:
+		data, hash, err = parseData(r)
+	}
+	switch {
+	case r.err != nil:
+		return nil, "", connectivityError(fmt.Errorf("unable to read input %w", r.err))
+	case err != nil:
+		return nil, "", parseError(err)
+	}
+	return data, hash, nil
+}
 
-		}
+// inputReader records read error of the input so failures of remote inputs
+// while they are streamed aren't reported as invalid content
+type inputReader struct {
+	r   io.Reader
+	err error
+}
+
+func (ir *inputReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if err != nil && err != io.EOF {
+		ir.err = err
+	}
+	return n, err
+}
+
+// withReaderAt returns reader which also implements io.ReaderAt when the
+// input does, so local snapshots are read in place rather than to memory
+func (ir *inputReader) withReaderAt() io.Reader {
+	if ra, ok := ir.r.(io.ReaderAt); ok {
+		return &inputReaderAt{inputReader: ir, ra: ra}
+	}
+	return ir
+}
+
+// inputReaderAt is inputReader of input with random access
+type inputReaderAt struct {
+	*inputReader
+	ra io.ReaderAt
+}
+
+func (ir *inputReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := ir.ra.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		ir.err = err
+	}
+	return n, err
+}
+
+// batchBuilder returns number of objects of given type and function to build cyphers for slice of them
+func batchBuilder(data *bloodHoundRawData, metaType string) (int, func(i, j int) map[string]*cypher) {
+	switch metaType {
+	case "computers":
+		return len(data.Computers), func(i, j int) map[string]*cypher { return buildComputerCyphers(data.Computers[i:j]) }
+	case "users":
+		return len(data.Users), func(i, j int) map[string]*cypher { return buildUserCyphers(data.Users[i:j]) }
+	case "groups":
+		return len(data.Groups), func(i, j int) map[string]*cypher { return buildGroupCyphers(data.Groups[i:j]) }
 	case "ous":
-		slice := data.OUs
-		for i := 0; i < len(slice); i += batch {
-			j := i + batch
-			if j > len(slice) {
-				j = len(slice)
-			}
-			select {
-			case <-ctx.Done():
-				return nil
-			case cypherChan <- buildOUCyphers(slice[i:j]):
-			}
+		return len(data.OUs), func(i, j int) map[string]*cypher { return buildOUCyphers(data.OUs[i:j]) }
+	case "gpos":
+		return len(data.Gpos), func(i, j int) map[string]*cypher { return buildGPOCyphers(data.Gpos[i:j]) }
+	case "domains":
+		return len(data.Domains), func(i, j int) map[string]*cypher { return buildDomainCyphers(data.Domains[i:j]) }
+	case metaTypeNodes:
+		return len(data.Nodes), func(i, j int) map[string]*cypher { return buildCustomNodeCyphers(data.Nodes[i:j]) }
+	case metaTypeEdges:
+		return len(data.Edges), func(i, j int) map[string]*cypher { return buildCustomEdgeCyphers(data.Edges[i:j]) }
+	}
+	return 0, nil
+}
+
+// queueData builds batches of given types and sends them to uploader,
+// batches committed by previous run are skipped.
+// batch index is continuous across types so it identifies batch within the input.
+// OUs and domains are added to the tree even if their batches are skipped.
+// custom data is queued once directory data of all inputs is queued
+func queueData(
+	ctx context.Context,
+	name string,
+	hash string,
+	data *bloodHoundRawData,
+	types []string,
+	cypherChan chan<- *batch,
+	cp *checkpoint,
+	summary *importSummary,
+	tree *gpoTree,
+	barrier *inputBarrier,
+) error {
+	logger := log.WithField("file", name)
+	tree.add(data, types)
+
+	batchSize := 10
+
+	committed := cp.lastBatch(hash)
+	if committed >= 0 {
+		logger.WithField("batch_index", committed).Info("resuming after last committed batch")
+	}
 
+	index := -1
+	for _, metaType := range types {
+		if isCustomType(metaType) {
+			barrier.arrive(name)
+			if err := barrier.wait(ctx); err != nil {
+				return err
+			}
 		}
-	case "gpos":
-		slice := data.Gpos
-		for i := 0; i < len(slice); i += batch {
-			j := i + batch
-			if j > len(slice) {
-				j = len(slice)
+		total, build := batchBuilder(data, metaType)
+		for i := 0; i < total; i += batchSize {
+			index++
+			if index <= committed {
+				continue
 			}
-			select {
-			case <-ctx.Done():
-				return nil
-			case cypherChan <- buildGPOCyphers(slice[i:j]):
+			j := i + batchSize
+			if j > total {
+				j = total
 			}
-
-		}
-	case "domains":
-		slice := data.Domains
-		for i := 0; i < len(slice); i += batch {
-			j := i + batch
-			if j > len(slice) {
-				j = len(slice)
+			b := &batch{
+				file:     name,
+				hash:     hash,
+				metaType: metaType,
+				index:    index,
+				cyphers:  build(i, j),
 			}
 			select {
 			case <-ctx.Done():
-				return nil
-			case cypherChan <- buildDomainCyphers(slice[i:j]):
+				return ctx.Err()
+			case cypherChan <- b:
+				summary.sent(name)
 			}
 		}
 	}
 
+	barrier.arrive(name)
+	summary.queued(name)
 	return nil
 }
 
-func cleanUp(object string, start time.Time, file string, deleteJsonFile bool) {
-	log.Infof("finished uploading %s data in %.2f min", object, time.Since(start).Minutes())
+// uploadData uploads each batch in a single transaction, once transaction is
+// committed batch is recorded in the checkpoint.
+// when ctx is cancelled batch in flight is given grace period to finish,
+// after that its transaction is rolled back and any remaining batches are discarded
+func uploadData(
+	ctx context.Context,
+	grace time.Duration,
+	driver neo4j.Driver,
+	cypherChan <-chan *batch,
+	cp *checkpoint,
+	summary *importSummary,
+) error {
+	session := driver.NewSession(neo4j.SessionConfig{
+		AccessMode: neo4j.AccessModeWrite,
+	})
+	defer session.Close()
 
-	if deleteJsonFile {
-		if err := os.Remove(file); err != nil {
-			log.Errorf("unable to delete %s err:%s", file, err)
+	abortCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	go func() {
+		select {
+		case <-abortCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+		log.Infof("waiting %s for in-flight batch to finish", grace)
+		select {
+		case <-abortCtx.Done():
+		case <-time.After(grace):
+			abort()
+		}
+	}()
+
+	for b := range cypherChan {
+		// drain channel so processors are not blocked
+		if ctx.Err() != nil {
+			continue
+		}
+		if err := uploadBatch(abortCtx, session, b, txTimeout(grace)); err != nil {
+			summary.failed(b.file, err)
+			return uploadError(err)
+		}
+		summary.committed(b.file)
+		if err := cp.commit(b.hash, b.index); err != nil {
+			log.WithFields(logrus.Fields{
+				"file":        b.file,
+				"batch_index": b.index,
+			}).WithError(err).Error("unable to update checkpoint")
 		}
 	}
+
+	return nil
 }
 
-func uploadData(wc *sync.WaitGroup, driver neo4j.Driver, cypherChan <-chan map[string]*cypher) error {
-	defer wc.Done()
+// batchTimeout is the longest time transaction of a single batch can take
+const batchTimeout = 1 * time.Minute
 
-	session := driver.NewSession(neo4j.SessionConfig{
-		AccessMode: neo4j.AccessModeWrite,
-	})
-	defer session.Close()
+// txTimeout returns transaction timeout of batches uploaded by the uploader,
+// it isn't longer than grace period so statement in flight on shutdown
+// doesn't outlive it as running statement can't be interrupted by the driver
+func txTimeout(grace time.Duration) time.Duration {
+	if grace > 0 && grace < batchTimeout {
+		return grace
+	}
+	return batchTimeout
+}
 
-	timeout := 1 * time.Minute
+// uploadBatch runs all cyphers of the batch in a single transaction,
+// transaction is rolled back if ctx is cancelled before commit
+func uploadBatch(ctx context.Context, session neo4j.Session, b *batch, timeout time.Duration) error {
+	tx, err := session.BeginTransaction(neo4j.WithTxTimeout(timeout))
+	if err != nil {
+		return err
+	}
+	// Close rolls back transaction if it wasn't committed
+	defer tx.Close()
 
-	for cyphers := range cypherChan {
-		for _, c := range cyphers {
-			if len(c.list) == 0 {
-				continue
-			}
-			_, err := session.Run(c.statement, map[string]interface{}{"list": c.list}, neo4j.WithTxTimeout(timeout))
-			if err != nil {
-				return err
+	for _, c := range b.cyphers {
+		if len(c.list) == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("batch %d of %s aborted: %w", b.index, b.file, err)
+		}
+		logger := log.WithFields(logrus.Fields{
+			"file":           b.file,
+			"meta_type":      b.metaType,
+			"batch_index":    b.index,
+			"statement_type": statementType(c.statement),
+			"rows":           len(c.list),
+		})
+		start := time.Now()
+		var matched interface{}
+		res, err := tx.Run(c.statement, map[string]interface{}{"list": c.list})
+		if err == nil {
+			// statements matching nodes by name return number of matched rows
+			if res.Next() {
+				matched, _ = res.Record().Get("matched")
 			}
+			_, err = res.Consume()
+		}
+		logger = logger.WithField("duration_ms", time.Since(start).Milliseconds())
+		if err != nil {
+			logger.WithField("objectid", c.objectIDs()).WithError(err).Error("unable to upload batch")
+			return err
 		}
+		if n, ok := matched.(int64); ok && n < int64(len(c.list)) {
+			logger.WithField("unmatched", int64(len(c.list))-n).Warn("rows which don't match existing nodes by name weren't uploaded")
+		}
+		logger.Debug("uploaded batch")
 	}
 
-	return nil
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("batch %d of %s aborted: %w", b.index, b.file, err)
+	}
+	return tx.Commit()
 }